@@ -2,13 +2,29 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/condratf/shortner/internal/app"
 )
 
+var commands = map[string]func(args []string) error{
+	"export":          app.Export,
+	"import":          app.Import,
+	"migrate-storage": app.MigrateStorage,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	err := app.Server()
 	if err != nil {
-		log.Fatal("server has crashed")
+		log.Fatalf("server has crashed: %v", err)
 	}
 }
@@ -0,0 +1,7 @@
+DROP INDEX idx_urls_created_at;
+
+DROP INDEX idx_urls_user_id;
+
+ALTER TABLE urls DROP COLUMN created_at;
+
+ALTER TABLE urls DROP COLUMN user_id;
\ No newline at end of file
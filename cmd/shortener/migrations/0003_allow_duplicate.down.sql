@@ -0,0 +1,11 @@
+DROP INDEX idx_urls_original_url_unique;
+
+DELETE FROM urls WHERE allow_duplicate;
+
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_key UNIQUE (original_url);
+
+ALTER TABLE urls DROP COLUMN metadata;
+
+ALTER TABLE urls DROP COLUMN tag;
+
+ALTER TABLE urls DROP COLUMN allow_duplicate;
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS allow_duplicate BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS tag TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key;
+
+CREATE UNIQUE INDEX idx_urls_original_url_unique ON urls(original_url) WHERE NOT allow_duplicate;
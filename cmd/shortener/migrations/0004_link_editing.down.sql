@@ -0,0 +1,5 @@
+DROP TABLE url_history;
+
+ALTER TABLE urls DROP COLUMN expires_at;
+
+ALTER TABLE urls DROP COLUMN redirect_type;
\ No newline at end of file
@@ -0,0 +1,15 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_type INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS url_history (
+  id BIGSERIAL PRIMARY KEY,
+  short_url TEXT NOT NULL,
+  original_url TEXT NOT NULL,
+  redirect_type INTEGER NOT NULL DEFAULT 0,
+  expires_at TIMESTAMPTZ,
+  changed_by TEXT NOT NULL,
+  changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_url_history_short_url ON url_history(short_url);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE urls DROP COLUMN clicks;
+
+ALTER TABLE urls DROP COLUMN max_clicks;
\ No newline at end of file
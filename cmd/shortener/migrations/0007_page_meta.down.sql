@@ -0,0 +1 @@
+ALTER TABLE urls DROP COLUMN page_meta;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE urls DROP COLUMN health;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS health JSONB;
\ No newline at end of file
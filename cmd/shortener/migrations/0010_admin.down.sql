@@ -0,0 +1 @@
+ALTER TABLE urls DROP COLUMN disabled;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT false;
\ No newline at end of file
@@ -1,42 +1,109 @@
 package app
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 
+	"github.com/condratf/shortner/internal/app/audit"
 	"github.com/condratf/shortner/internal/app/config"
 	"github.com/condratf/shortner/internal/app/db"
 	"github.com/condratf/shortner/internal/app/logger"
 	"github.com/condratf/shortner/internal/app/router"
 	"github.com/condratf/shortner/internal/app/shortener"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/tracing"
 
 	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
 )
 
 func Server() error {
-	config.InitConfig()
+	// логгер ещё не настроен, поэтому ошибка только возвращается
+	if err := config.InitConfig(); err != nil {
+		return fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	log, err := initLogger()
+	if err != nil {
+		return err
+	}
+	defer log.Sync()
+
+	shutdownTracing, err := initTracing()
+	if err != nil {
+		log.Error("failed to initialize tracing", zap.Error(err))
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error("failed to flush traces", zap.Error(err))
+		}
+	}()
+
+	verifyJWT, err := initJWTVerifier()
+	if err != nil {
+		log.Error("failed to initialize authentication", zap.Error(err))
+		return err
+	}
+	notFound, err := initNotFoundHandler()
+	if err != nil {
+		log.Error("failed to initialize not found handler", zap.Error(err))
+		return err
+	}
 	short := shortener.NewShortener()
 	store, err := initStore()
 	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
+		log.Error("failed to initialize storage", zap.Error(err))
 		return err
 	}
+	store = storage.WithLogging(store)
 	if db.DB != nil {
 		defer db.CloseDB()
 	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	auditLog, err := audit.OpenFile(config.Config.AuditLogPath)
+	if err != nil {
+		log.Error("failed to open audit log", zap.Error(err))
+		return err
+	}
+	defer auditLog.Close()
+
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background(), log))
+	defer cancel()
+	unfurler := initUnfurler(ctx, store, startFileSaver(ctx, store, fileSaveDelay))
+	initHealthChecker(ctx, store)
 
 	r := chi.NewRouter()
-	r.Use(logger.LoggingMiddleware())
-
-	shortenerRouter := router.ShortenerRouter(
-		shortURLAndStore(short, store),
-		getURL(store),
-		shortURLAndStoreBatch(short, store),
-		db.PingDB,
-	)
+	r.Use(tracing.Middleware)
+	r.Use(logger.LoggingMiddleware(log))
+
+	shortenerRouter := router.ShortenerRouter(router.Handlers{
+		ShortURLAndStore:      shortURLAndStore(short, store, unfurler),
+		GetURL:                getURL(store),
+		UnlockURL:             unlockURL(store),
+		ClickURL:              clickURL(store),
+		ShortURLAndStoreBatch: shortURLAndStoreBatch(short, store, unfurler),
+		PingDB:                db.PingDB,
+		ExportURLs:            exportURLs(store),
+		UpdateURL:             updateURL(store, unfurler),
+		URLHistory:            urlHistory(store),
+		BrokenURLs:            brokenURLs(store),
+		CreateAPIKey:          createAPIKey(store),
+		ListAPIKeys:           listAPIKeys(store),
+		DeleteAPIKey:          deleteAPIKey(store),
+		LookupAPIKey:          lookupAPIKey(store),
+		VerifyJWT:             verifyJWT,
+		AdminSearch:           adminSearch(store, auditLog),
+		AdminSetDisabled:      adminSetDisabled(store, auditLog),
+		AdminDelete:           adminDelete(store, auditLog),
+		NotFound:              notFound,
+	})
 	r.Mount("/", shortenerRouter)
-	fmt.Printf("starting server at :%s\n", config.Config.Addr)
+	log.Info("starting server", zap.String("addr", config.Config.Addr))
 
 	return http.ListenAndServe(config.Config.Addr, r)
 }
@@ -0,0 +1,84 @@
+// Package audit ведёт журнал действий администраторов: по одной
+// JSON-записи на строку, без перезаписи прежних записей.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Действия администраторов.
+const (
+	ActionSearch  = "search"
+	ActionDisable = "disable"
+	ActionEnable  = "enable"
+	ActionDelete  = "delete"
+)
+
+// Entry — запись журнала. Details содержит параметры действия,
+// например условия поиска.
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	Actor    string            `json:"actor"`
+	Action   string            `json:"action"`
+	ShortURL string            `json:"short_url,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// Log дописывает записи в w. Если w — файл, каждая запись
+// сбрасывается на диск до возврата из Record.
+type Log struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+func NewLog(w io.Writer) *Log {
+	return &Log{w: w, now: time.Now}
+}
+
+// OpenFile открывает журнал в файле path для дозаписи.
+func OpenFile(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+	return NewLog(file), nil
+}
+
+// Close закрывает файл журнала, если журнал открыт через OpenFile.
+func (l *Log) Close() error {
+	if closer, ok := l.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Record записывает entry; пустое время заменяется текущим.
+func (l *Log) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = l.now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.w.Write(line); err != nil {
+		return fmt.Errorf("could not write audit log: %w", err)
+	}
+	if file, ok := l.w.(*os.File); ok {
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("could not sync audit log: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		// журнал дописывается и после повторного открытия
+		log, err := OpenFile(path)
+		require.NoError(t, err)
+		log.now = func() time.Time { return now }
+		assert.NoError(t, log.Record(Entry{Actor: "admin", Action: ActionDisable, ShortURL: "abc"}))
+		assert.NoError(t, log.Close())
+	}
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, []Entry{
+		{Time: now, Actor: "admin", Action: ActionDisable, ShortURL: "abc"},
+		{Time: now, Actor: "admin", Action: ActionDisable, ShortURL: "abc"},
+	}, entries)
+}
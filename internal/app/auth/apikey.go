@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Права ключей доступа.
+const (
+	ScopeShorten = "shorten"
+	ScopeRead    = "read"
+)
+
+// Scopes перечисляет все права, которые можно выдать ключу.
+var Scopes = []string{ScopeShorten, ScopeRead}
+
+const (
+	apiKeyPrefix = "sk_"
+	// apiKeyBytes — длина случайной части ключа
+	apiKeyBytes = 32
+	// apiKeyDisplayLen — длина начала ключа, которое показывается в списке ключей
+	apiKeyDisplayLen = len(apiKeyPrefix) + 8
+)
+
+// ValidScope сообщает, существует ли право scope.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateAPIKey создаёт новый ключ доступа и возвращает его вместе
+// с началом для отображения и хешем для хранения.
+func GenerateAPIKey() (token, prefix, hash string, err error) {
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	token = apiKeyPrefix + hex.EncodeToString(b)
+	return token, token[:apiKeyDisplayLen], HashAPIKey(token), nil
+}
+
+// HashAPIKey возвращает хеш ключа, под которым он хранится. Ключ содержит
+// 256 случайных бит, поэтому медленный хеш с солью ему не нужен.
+func HashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/google/uuid"
+)
+
+const CookieName = "user_id"
+
+type contextKey struct{}
+
+// Identity — пользователь, от имени которого выполняется запрос.
+// Authenticated ложно, если идентификатор был выдан только что,
+// то есть клиент не предъявил действительную подписанную куку.
+// Для запросов с ключом доступа APIKeyID и Scopes описывают этот ключ,
+// Roles приходят из claim roles в JWT. Bearer истинно, если пользователь
+// подтверждён ключом доступа или JWT, а не кукой, которую сервис выдаёт
+// любому клиенту.
+type Identity struct {
+	UserID        string
+	Authenticated bool
+	Bearer        bool
+	APIKeyID      string
+	Scopes        []string
+	Roles         []string
+}
+
+// RoleAdmin — роль модератора, которому доступен /api/admin.
+const RoleAdmin = "admin"
+
+// IsAdmin сообщает, может ли пользователь модерировать ссылки: роль
+// admin выдаётся в JWT или пользователь перечислен в config.AdminUsers.
+// Ключам доступа права администратора не передаются.
+func (id Identity) IsAdmin() bool {
+	if !id.Authenticated || id.APIKeyID != "" {
+		return false
+	}
+	for _, role := range id.Roles {
+		if role == RoleAdmin {
+			return true
+		}
+	}
+	for _, userID := range config.Config.AdminUsers {
+		if userID == id.UserID {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope сообщает, разрешено ли действие scope. Ограничения действуют
+// только для ключей доступа: пользователю с кукой разрешено всё.
+func (id Identity) HasScope(scope string) bool {
+	if id.APIKeyID == "" {
+		return true
+	}
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenResolver определяет пользователя по токену из заголовка
+// Authorization. Для недействительного токена возвращает
+// ErrInvalidAPIKey или ErrInvalidToken.
+type TokenResolver func(ctx context.Context, token string) (Identity, error)
+
+// Режимы аутентификации пользователей.
+const (
+	ModeCookie = "cookie"
+	ModeJWT    = "jwt"
+)
+
+// Options — способы аутентификации. В режиме ModeCookie клиентам выдаётся
+// подписанная кука, в режиме ModeJWT пользователь определяется по JWT
+// через VerifyJWT, а запросы без токена анонимны. Ключи доступа
+// принимаются в обоих режимах через LookupAPIKey; nil отключает способ.
+type Options struct {
+	Mode         string
+	LookupAPIKey TokenResolver
+	VerifyJWT    TokenResolver
+}
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+var (
+	secretOnce sync.Once
+	secret     []byte
+)
+
+func signingKey() []byte {
+	secretOnce.Do(func() {
+		if config.Config.AuthSecret != "" {
+			secret = []byte(config.Config.AuthSecret)
+			return
+		}
+		// без заданного секрета куки действуют до перезапуска сервера
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	})
+	return secret
+}
+
+func sign(userID string) string {
+	mac := hmac.New(sha256.New, signingKey())
+	mac.Write([]byte(userID))
+	return userID + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func verify(value string) (string, bool) {
+	userID, _, ok := strings.Cut(value, ".")
+	if !ok || userID == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(sign(userID)), []byte(value)) {
+		return "", false
+	}
+	return userID, true
+}
+
+// Middleware определяет пользователя по токену из заголовка
+// Authorization: Bearer или по подписанной куке и кладёт его в контекст
+// запроса. В режиме ModeCookie клиенту без токена и без действительной
+// куки выдаётся новая кука.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := bearerToken(r); ok {
+				resolve := opts.VerifyJWT
+				if strings.HasPrefix(token, apiKeyPrefix) {
+					resolve = opts.LookupAPIKey
+				} else if opts.Mode != ModeJWT {
+					resolve = nil
+				}
+				serveWithToken(w, r, next, resolve, token)
+				return
+			}
+
+			if opts.Mode == ModeJWT {
+				next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), Identity{})))
+				return
+			}
+
+			if cookie, err := r.Cookie(CookieName); err == nil {
+				if userID, ok := verify(cookie.Value); ok {
+					next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), Identity{UserID: userID, Authenticated: true})))
+					return
+				}
+			}
+
+			userID := uuid.New().String()
+			http.SetCookie(w, &http.Cookie{
+				Name:     CookieName,
+				Value:    sign(userID),
+				Path:     "/",
+				HttpOnly: true,
+			})
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), Identity{UserID: userID})))
+		})
+	}
+}
+
+func serveWithToken(w http.ResponseWriter, r *http.Request, next http.Handler, resolve TokenResolver, token string) {
+	if resolve == nil {
+		unauthorizedToken(w, r)
+		return
+	}
+
+	id, err := resolve(r.Context(), token)
+	if errors.Is(err, ErrInvalidAPIKey) || errors.Is(err, ErrInvalidToken) {
+		unauthorizedToken(w, r)
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err, "could not check token"))
+		return
+	}
+	id.Authenticated = true
+	id.Bearer = true
+	next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+}
+
+func unauthorizedToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	problem.Write(w, r, problem.New(problem.ErrUnauthorized, ErrInvalidToken.Error()))
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// RequireUser пропускает только запросы с действительной кукой пользователя.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !FromContext(r.Context()).Authenticated {
+			problem.Write(w, r, problem.ErrUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope отклоняет запросы с ключом доступа, которому не выдан scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !FromContext(r.Context()).HasScope(scope) {
+				problem.Write(w, r, problem.New(problem.ErrForbidden, "api key lacks scope "+scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession пропускает только пользователей с действительной кукой:
+// ключом доступа нельзя, например, выпустить другой ключ.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := FromContext(r.Context())
+		if !id.Authenticated {
+			problem.Write(w, r, problem.ErrUnauthorized)
+			return
+		}
+		if id.APIKeyID != "" {
+			problem.Write(w, r, problem.New(problem.ErrForbidden, "api keys cannot be used here"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin пропускает только администраторов.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := FromContext(r.Context())
+		if !id.Authenticated {
+			problem.Write(w, r, problem.ErrUnauthorized)
+			return
+		}
+		if !id.IsAdmin() {
+			problem.Write(w, r, problem.New(problem.ErrForbidden, "admin role required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+func FromContext(ctx context.Context) Identity {
+	id, _ := ctx.Value(contextKey{}).(Identity)
+	return id
+}
+
+func UserIDFromContext(ctx context.Context) string {
+	return FromContext(ctx).UserID
+}
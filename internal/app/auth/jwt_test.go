@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeJWKS(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) string {
+	t.Helper()
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	set := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "oct", "kid": "ignored", "k": "c2VjcmV0"},
+		},
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	secret := []byte("shared-secret")
+	verify, err := NewJWTVerifier(JWTOptions{
+		Secret:   secret,
+		JWKSFile: writeJWKS(t, rsaKey, ecKey),
+		Audience: "shortener",
+		Issuer:   "https://sso.example.com",
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	claims := func(mod func(c *jwt.RegisteredClaims)) jwt.RegisteredClaims {
+		c := jwt.RegisteredClaims{
+			Subject:   "user-42",
+			Audience:  jwt.ClaimStrings{"shortener"},
+			Issuer:    "https://sso.example.com",
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+		if mod != nil {
+			mod(&c)
+		}
+		return c
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, kid string, c jwt.RegisteredClaims) string {
+		token := jwt.NewWithClaims(method, c)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{name: "HS256", token: sign(jwt.SigningMethodHS256, secret, "", claims(nil)), valid: true},
+		{name: "RS256 with kid", token: sign(jwt.SigningMethodRS256, rsaKey, "rsa-1", claims(nil)), valid: true},
+		{name: "RS256 without kid", token: sign(jwt.SigningMethodRS256, rsaKey, "", claims(nil)), valid: true},
+		{name: "ES256", token: sign(jwt.SigningMethodES256, ecKey, "", claims(nil)), valid: true},
+		{name: "expired", token: sign(jwt.SigningMethodHS256, secret, "", claims(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Hour))
+		}))},
+		{name: "without exp", token: sign(jwt.SigningMethodHS256, secret, "", claims(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = nil
+		}))},
+		{name: "not yet valid", token: sign(jwt.SigningMethodHS256, secret, "", claims(func(c *jwt.RegisteredClaims) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+		}))},
+		{name: "wrong audience", token: sign(jwt.SigningMethodHS256, secret, "", claims(func(c *jwt.RegisteredClaims) {
+			c.Audience = jwt.ClaimStrings{"other"}
+		}))},
+		{name: "wrong issuer", token: sign(jwt.SigningMethodHS256, secret, "", claims(func(c *jwt.RegisteredClaims) {
+			c.Issuer = "https://evil.example.com"
+		}))},
+		{name: "without sub", token: sign(jwt.SigningMethodHS256, secret, "", claims(func(c *jwt.RegisteredClaims) {
+			c.Subject = ""
+		}))},
+		{name: "wrong secret", token: sign(jwt.SigningMethodHS256, []byte("other"), "", claims(nil))},
+		{name: "unknown RSA key", token: sign(jwt.SigningMethodRS256, otherKey, "rsa-1", claims(nil))},
+		{name: "unknown kid", token: sign(jwt.SigningMethodRS256, rsaKey, "rsa-2", claims(nil))},
+		{name: "unsupported algorithm", token: sign(jwt.SigningMethodHS512, secret, "", claims(nil))},
+		{name: "none algorithm", token: sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims(nil))},
+		{name: "garbage", token: "not.a.token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := verify(context.Background(), tt.token)
+			if !tt.valid {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user-42", id.UserID)
+		})
+	}
+}
+
+func TestNewJWTVerifier_Errors(t *testing.T) {
+	_, err := NewJWTVerifier(JWTOptions{})
+	assert.Error(t, err)
+
+	_, err = NewJWTVerifier(JWTOptions{JWKSFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AAAA","y":"AAAA"}]}`), 0600))
+	_, err = NewJWTVerifier(JWTOptions{JWKSFile: path})
+	assert.Error(t, err)
+}
+
+func TestMiddleware_JWTMode(t *testing.T) {
+	handler := Middleware(Options{
+		Mode: ModeJWT,
+		VerifyJWT: func(_ context.Context, token string) (Identity, error) {
+			if token != "good.jwt.token" {
+				return Identity{}, ErrInvalidToken
+			}
+			return Identity{UserID: "user-42"}, nil
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := FromContext(r.Context())
+		if id.Authenticated {
+			w.Write([]byte(id.UserID))
+		}
+	}))
+
+	do := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := do("Bearer good.jwt.token")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "user-42", recorder.Body.String())
+
+	recorder = do("Bearer bad.jwt.token")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// без токена запрос анонимный и кука не выдаётся
+	recorder = do("")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Body.String())
+	assert.Empty(t, recorder.Result().Cookies())
+
+	// ключи доступа без LookupAPIKey не принимаются
+	recorder = do("Bearer sk_123")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestJWTVerifier_Roles(t *testing.T) {
+	secret := []byte("shared-secret")
+	verify, err := NewJWTVerifier(JWTOptions{Secret: secret})
+	require.NoError(t, err)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   "moderator",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"support", RoleAdmin},
+	}).SignedString(secret)
+	require.NoError(t, err)
+
+	id, err := verify(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"support", RoleAdmin}, id.Roles)
+
+	assert.False(t, id.IsAdmin(), "identity is not authenticated yet")
+	id.Authenticated = true
+	assert.True(t, id.IsAdmin())
+	id.APIKeyID = "key"
+	assert.False(t, id.IsAdmin(), "api keys never act as admins")
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/condratf/shortner/internal/app/config"
@@ -12,6 +11,7 @@ import (
 	"github.com/golang-migrate/migrate/database/postgres"
 	_ "github.com/golang-migrate/migrate/source/file"
 	_ "github.com/lib/pq"
+	"go.uber.org/zap"
 )
 
 const (
@@ -48,7 +48,7 @@ func PingDB(ctx context.Context) error {
 }
 
 func CloseDB() error {
-	log.Println("closing the database connection")
+	zap.L().Info("closing the database connection")
 	return DB.Close()
 }
 
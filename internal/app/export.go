@@ -0,0 +1,70 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/db"
+	"github.com/condratf/shortner/internal/app/export"
+	"github.com/condratf/shortner/internal/app/storage"
+)
+
+// Export выгружает ссылки из настроенного хранилища:
+//
+//	shortener export [-format csv|json|ndjson] [-user ID] [-from DATE] [-to DATE] [-o FILE]
+func Export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", string(export.FormatJSON), "Export format: csv, json or ndjson")
+	output := fs.String("o", "", "Output file, stdout if empty")
+	userID := fs.String("user", "", "Export only links of this user")
+	from := fs.String("from", "", "Export links created at or after this date (RFC 3339 or YYYY-MM-DD)")
+	to := fs.String("to", "", "Export links created before this date (RFC 3339 or YYYY-MM-DD)")
+
+	if err := config.ParseFlags(fs, args); err != nil {
+		return err
+	}
+
+	exportFormat, err := export.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	filter := storage.ListFilter{UserID: *userID}
+	if filter.From, err = export.ParseDate(*from); err != nil {
+		return err
+	}
+	if filter.To, err = export.ParseDate(*to); err != nil {
+		return err
+	}
+
+	store, err := initStore()
+	if err != nil {
+		return err
+	}
+	if db.DB != nil {
+		defer db.CloseDB()
+	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("could not create output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	writer := bufio.NewWriter(out)
+	if err := export.Write(context.Background(), writer, exportFormat, store, filter); err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,200 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/storage"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatJSON   Format = "json"
+	FormatNDJSON Format = "ndjson"
+)
+
+// pageSize — сколько записей за раз читается из хранилища.
+const pageSize = 500
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatCSV, FormatJSON, FormatNDJSON:
+		return f, nil
+	case "":
+		return FormatJSON, nil
+	default:
+		return "", fmt.Errorf("unsupported export format: %s", s)
+	}
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/json"
+	}
+}
+
+type encoder interface {
+	encode(data storage.URLData) error
+	close() error
+}
+
+// Write потоково выгружает в w все ссылки из store, подходящие под filter.
+func Write(ctx context.Context, w io.Writer, format Format, store storage.Storage, filter storage.ListFilter) error {
+	var enc encoder
+	switch format {
+	case FormatCSV:
+		enc = newCSVEncoder(w)
+	case FormatNDJSON:
+		enc = &ndjsonEncoder{enc: json.NewEncoder(w)}
+	case FormatJSON:
+		enc = &jsonArrayEncoder{w: w}
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	filter.Limit = pageSize
+	if err := storage.Iterate(ctx, store, filter, enc.encode); err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+var csvHeader = []string{"uuid", "short_url", "original_url", "user_id", "created_at"}
+
+// record — выгружаемая ссылка в JSON-форматах: те же поля, что и в CSV.
+// Хеш пароля, счётчики и служебные сведения хранилища в выгрузку не попадают.
+type record struct {
+	UUID        string    `json:"uuid"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	UserID      string    `json:"user_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func newRecord(data storage.URLData) record {
+	return record{
+		UUID:        data.UUID,
+		ShortURL:    data.ShortURL,
+		OriginalURL: data.OriginalURL,
+		UserID:      data.UserID,
+		CreatedAt:   data.CreatedAt,
+	}
+}
+
+type csvEncoder struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func newCSVEncoder(w io.Writer) *csvEncoder {
+	return &csvEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvEncoder) writeHeader() error {
+	if e.headerWritten {
+		return nil
+	}
+	e.headerWritten = true
+	return e.w.Write(csvHeader)
+}
+
+func (e *csvEncoder) encode(data storage.URLData) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	return e.w.Write([]string{
+		data.UUID,
+		data.ShortURL,
+		data.OriginalURL,
+		data.UserID,
+		formatTime(data.CreatedAt),
+	})
+}
+
+// formatTime оставляет пустую ячейку для записей без даты создания,
+// например загруженных из файла старого формата.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (e *csvEncoder) close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) encode(data storage.URLData) error {
+	return e.enc.Encode(newRecord(data))
+}
+
+func (e *ndjsonEncoder) close() error {
+	return nil
+}
+
+type jsonArrayEncoder struct {
+	w       io.Writer
+	started bool
+}
+
+func (e *jsonArrayEncoder) encode(data storage.URLData) error {
+	b, err := json.Marshal(newRecord(data))
+	if err != nil {
+		return err
+	}
+
+	sep := ","
+	if !e.started {
+		sep = "["
+		e.started = true
+	}
+	if _, err := io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *jsonArrayEncoder) close() error {
+	if !e.started {
+		_, err := io.WriteString(e.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// ParseDate разбирает границу интервала выгрузки: RFC 3339 или дату вида 2006-01-02.
+// Пустая строка означает отсутствие границы.
+func ParseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: expected RFC 3339 or YYYY-MM-DD", s)
+	}
+	return t, nil
+}
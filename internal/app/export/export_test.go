@@ -0,0 +1,85 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	store := storage.NewInMemoryStore()
+	created := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	for i, userID := range []string{"alice", "bob", "alice"} {
+		_, err := store.Save(context.Background(), storage.URLData{
+			UUID:        fmt.Sprintf("uuid-%d", i),
+			ShortURL:    fmt.Sprintf("key%d", i),
+			OriginalURL: fmt.Sprintf("http://example.com/%d", i),
+			UserID:      userID,
+			CreatedAt:   created.AddDate(0, 0, i),
+			// служебные поля не выгружаются
+			PasswordHash: "$2a$10$hash",
+			MaxClicks:    5,
+			PageMeta:     &storage.PageMeta{Title: "Example"},
+			Health:       &storage.LinkHealth{Status: 200},
+			Disabled:     i == 1,
+		})
+		assert.NoError(t, err)
+	}
+
+	tests := []struct {
+		name     string
+		format   Format
+		filter   storage.ListFilter
+		expected string
+	}{
+		{
+			name:   "csv for one user",
+			format: FormatCSV,
+			filter: storage.ListFilter{UserID: "alice"},
+			expected: "uuid,short_url,original_url,user_id,created_at\n" +
+				"uuid-0,key0,http://example.com/0,alice,2024-01-10T12:00:00Z\n" +
+				"uuid-2,key2,http://example.com/2,alice,2024-01-12T12:00:00Z\n",
+		},
+		{
+			name:     "csv with no matches still has header",
+			format:   FormatCSV,
+			filter:   storage.ListFilter{UserID: "carol"},
+			expected: "uuid,short_url,original_url,user_id,created_at\n",
+		},
+		{
+			name:   "ndjson by date range",
+			format: FormatNDJSON,
+			filter: storage.ListFilter{From: created.AddDate(0, 0, 1), To: created.AddDate(0, 0, 2)},
+			expected: `{"uuid":"uuid-1","short_url":"key1","original_url":"http://example.com/1","user_id":"bob","created_at":"2024-01-11T12:00:00Z"}` +
+				"\n",
+		},
+		{
+			name:   "json array",
+			format: FormatJSON,
+			filter: storage.ListFilter{From: created.AddDate(0, 0, 1)},
+			expected: `[{"uuid":"uuid-1","short_url":"key1","original_url":"http://example.com/1","user_id":"bob","created_at":"2024-01-11T12:00:00Z"},` +
+				`{"uuid":"uuid-2","short_url":"key2","original_url":"http://example.com/2","user_id":"alice","created_at":"2024-01-12T12:00:00Z"}]` +
+				"\n",
+		},
+		{
+			name:     "empty json array",
+			format:   FormatJSON,
+			filter:   storage.ListFilter{UserID: "carol"},
+			expected: "[]\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := Write(context.Background(), &buf, tt.format, store, tt.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
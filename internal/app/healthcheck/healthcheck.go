@@ -0,0 +1,274 @@
+// Package healthcheck периодически проверяет доступность страниц назначения
+// и сохраняет результат проверки для каждой ссылки.
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/logger"
+	"github.com/condratf/shortner/internal/app/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultTimeout   = 10 * time.Second
+	DefaultWorkers   = 4
+	DefaultHostDelay = time.Second
+
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+	// maxBodyBytes — сколько тела GET-ответа читать перед закрытием соединения
+	maxBodyBytes = 64 << 10
+)
+
+// Options — параметры проверки. Rate — общее число проверок в секунду,
+// PerHost — число одновременных запросов к одному хосту, HostDelay —
+// минимальный интервал между запросами к одному хосту. Flush, если задан,
+// вызывается после каждого обхода, чтобы сохранить результаты разом.
+type Options struct {
+	Interval  time.Duration
+	Rate      float64
+	Workers   int
+	PerHost   int
+	HostDelay time.Duration
+	Flush     func(ctx context.Context) error
+}
+
+// Checker обходит все ссылки хранилища пулом обработчиков.
+type Checker struct {
+	client *http.Client
+	store  storage.Storage
+	save   func(ctx context.Context, shortURL string, health storage.LinkHealth) error
+	opts   Options
+	now    func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState ограничивает нагрузку на один хост: семафор на число
+// одновременных запросов, время следующего разрешённого запроса
+// и экспоненциальная пауза после ошибок хоста.
+type hostState struct {
+	sem          chan struct{}
+	next         time.Time
+	backoff      time.Duration
+	blockedUntil time.Time
+}
+
+func NewChecker(
+	client *http.Client,
+	store storage.Storage,
+	save func(ctx context.Context, shortURL string, health storage.LinkHealth) error,
+	opts Options,
+) *Checker {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.PerHost <= 0 {
+		opts.PerHost = 1
+	}
+	return &Checker{
+		client: client,
+		store:  store,
+		save:   save,
+		opts:   opts,
+		now:    time.Now,
+		hosts:  make(map[string]*hostState),
+	}
+}
+
+// Run проверяет ссылки каждые opts.Interval, пока не завершится ctx.
+func (c *Checker) Run(ctx context.Context) {
+	for {
+		if err := c.RunOnce(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			logger.FromContext(ctx).Error("health check failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.opts.Interval):
+		}
+	}
+}
+
+// RunOnce проверяет все ссылки один раз.
+func (c *Checker) RunOnce(ctx context.Context) error {
+	jobs := make(chan storage.URLData)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for data := range jobs {
+				c.check(ctx, data)
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if c.opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / c.opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	err := storage.Iterate(ctx, c.store, storage.ListFilter{}, func(data storage.URLData) error {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-tick:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case jobs <- data:
+			return nil
+		}
+	})
+	close(jobs)
+	wg.Wait()
+
+	// сохранённое до отмены тоже записывается
+	if c.opts.Flush != nil {
+		if flushErr := c.opts.Flush(ctx); flushErr != nil {
+			logger.FromContext(ctx).Error("could not persist health check results", zap.Error(flushErr))
+		}
+	}
+
+	return err
+}
+
+func (c *Checker) check(ctx context.Context, data storage.URLData) {
+	u, err := url.Parse(data.OriginalURL)
+	if err != nil || u.Host == "" {
+		return
+	}
+
+	release, ok := c.acquire(ctx, u.Host)
+	if !ok {
+		// хост отдыхает после ошибок — ссылку проверим в следующий проход
+		return
+	}
+	health, transient := c.probe(ctx, data.OriginalURL)
+	// недоступный хост тоже получает паузу, хотя результат сохраняется
+	release(transient || health.Error != "")
+
+	if transient || ctx.Err() != nil {
+		return
+	}
+	if err := c.save(ctx, data.ShortURL, health); err != nil {
+		logger.FromContext(ctx).Error("could not save health check result",
+			zap.String("short_key", data.ShortURL), zap.Error(err))
+	}
+}
+
+// acquire ждёт своей очереди к хосту. Возвращает false, если хост
+// на паузе после ошибок или ctx завершён.
+func (c *Checker) acquire(ctx context.Context, host string) (func(failed bool), bool) {
+	c.mu.Lock()
+	state, ok := c.hosts[host]
+	if !ok {
+		state = &hostState{sem: make(chan struct{}, c.opts.PerHost)}
+		c.hosts[host] = state
+	}
+	blocked := c.now().Before(state.blockedUntil)
+	c.mu.Unlock()
+	if blocked {
+		return nil, false
+	}
+
+	select {
+	case state.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false
+	}
+
+	c.mu.Lock()
+	now := c.now()
+	if now.Before(state.blockedUntil) {
+		c.mu.Unlock()
+		<-state.sem
+		return nil, false
+	}
+	wait := state.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	state.next = now.Add(wait + c.opts.HostDelay)
+	c.mu.Unlock()
+
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			<-state.sem
+			return nil, false
+		}
+	}
+
+	return func(failed bool) {
+		c.mu.Lock()
+		if failed {
+			state.backoff *= 2
+			if state.backoff < minBackoff {
+				state.backoff = minBackoff
+			}
+			if state.backoff > maxBackoff {
+				state.backoff = maxBackoff
+			}
+			state.blockedUntil = c.now().Add(state.backoff)
+		} else {
+			state.backoff = 0
+		}
+		c.mu.Unlock()
+		<-state.sem
+	}, true
+}
+
+// probe выполняет HEAD, а если хост его не поддерживает — GET.
+// transient означает, что хост перегружен и результат не стоит сохранять.
+func (c *Checker) probe(ctx context.Context, rawURL string) (health storage.LinkHealth, transient bool) {
+	start := c.now()
+	status, err := c.request(ctx, http.MethodHead, rawURL)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = c.request(ctx, http.MethodGet, rawURL)
+	}
+
+	health = storage.LinkHealth{
+		Status:    status,
+		LatencyMS: c.now().Sub(start).Milliseconds(),
+		CheckedAt: c.now().UTC(),
+	}
+	if err != nil {
+		health.Error = err.Error()
+		return health, ctx.Err() != nil
+	}
+	return health, status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+func (c *Checker) request(ctx context.Context, method, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "shortener-healthcheck/1.0")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodyBytes))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
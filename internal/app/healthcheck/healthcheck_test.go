@@ -0,0 +1,132 @@
+package healthcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_RunOnce(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/head-not-allowed":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	// перегруженный хост откладывает свои проверки, поэтому он отдельный
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer busy.Close()
+
+	store := storage.NewInMemoryStore()
+	for key, path := range map[string]string{
+		"a": "/ok",
+		"b": "/gone",
+		"c": "/head-not-allowed",
+		"e": "/ok",
+	} {
+		_, err := store.Save(context.Background(), storage.URLData{ShortURL: key, OriginalURL: server.URL + path, AllowDuplicate: true})
+		assert.NoError(t, err)
+	}
+	_, err := store.Save(context.Background(), storage.URLData{ShortURL: "d", OriginalURL: busy.URL + "/busy"})
+	assert.NoError(t, err)
+	_, err = store.Save(context.Background(), storage.URLData{ShortURL: "f", OriginalURL: "http://127.0.0.1:1/closed"})
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	results := make(map[string]storage.LinkHealth)
+	save := func(_ context.Context, shortURL string, health storage.LinkHealth) error {
+		mu.Lock()
+		defer mu.Unlock()
+		results[shortURL] = health
+		return store.SetHealth(context.Background(), shortURL, health)
+	}
+
+	var flushes, flushed int
+	checker := NewChecker(&http.Client{Timeout: time.Second}, store, save, Options{
+		Rate:      1000,
+		Workers:   4,
+		PerHost:   1,
+		HostDelay: time.Millisecond,
+		Flush: func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			flushes++
+			flushed = len(results)
+			return nil
+		},
+	})
+	assert.NoError(t, checker.RunOnce(context.Background()))
+	assert.Equal(t, 1, flushes, "results are persisted once per pass")
+	assert.Equal(t, len(results), flushed, "flush runs after every link is checked")
+
+	assert.EqualValues(t, 1, maxInFlight, "a single host must not get parallel requests")
+
+	assert.Equal(t, http.StatusOK, results["a"].Status)
+	assert.Equal(t, http.StatusNotFound, results["b"].Status)
+	assert.True(t, results["b"].Broken())
+	assert.Equal(t, http.StatusOK, results["c"].Status, "GET is used when HEAD is not allowed")
+	assert.NotContains(t, results, "d", "overloaded host result is not stored")
+	assert.NotEmpty(t, results["f"].Error)
+	assert.False(t, results["f"].CheckedAt.IsZero())
+
+	data, err := store.Get(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, data.Health.Status)
+}
+
+func TestChecker_Backoff(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	checker := NewChecker(http.DefaultClient, nil, nil, Options{})
+	checker.now = func() time.Time { return now }
+
+	release, ok := checker.acquire(context.Background(), "example.com")
+	assert.True(t, ok)
+	release(true)
+
+	// после ошибки хост на паузе
+	_, ok = checker.acquire(context.Background(), "example.com")
+	assert.False(t, ok)
+	_, ok = checker.acquire(context.Background(), "other.example.com")
+	assert.True(t, ok)
+
+	now = now.Add(minBackoff)
+	release, ok = checker.acquire(context.Background(), "example.com")
+	assert.True(t, ok)
+	release(true)
+	assert.Equal(t, 2*minBackoff, checker.hosts["example.com"].backoff)
+
+	now = now.Add(2 * minBackoff)
+	release, ok = checker.acquire(context.Background(), "example.com")
+	assert.True(t, ok)
+	release(false)
+	assert.Zero(t, checker.hosts["example.com"].backoff)
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/db"
+	"github.com/condratf/shortner/internal/app/importer"
+)
+
+// Import загружает ссылки из дампов CSV или NDJSON с сохранением ключей:
+//
+//	shortener import [-format csv|ndjson] [-chunk N] [-report FILE] [-dry-run] FILE...
+func Import(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", string(importer.FormatCSV), "Input format: csv or ndjson")
+	chunkSize := fs.Int("chunk", importer.DefaultChunkSize, "Number of records saved per batch")
+	reportPath := fs.String("report", "import-report.csv", "File for rejected records and conflicts")
+	dryRun := fs.Bool("dry-run", false, "Validate input and report the conflicts an import would hit, without saving")
+
+	if err := config.ParseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no input files")
+	}
+
+	inputFormat, err := importer.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	store, err := initStore()
+	if err != nil {
+		return err
+	}
+	if db.DB != nil {
+		defer db.CloseDB()
+	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	reportFile, err := os.Create(*reportPath)
+	if err != nil {
+		return fmt.Errorf("could not create report file: %w", err)
+	}
+	defer reportFile.Close()
+
+	im := &importer.Importer{
+		Store:     store,
+		ChunkSize: *chunkSize,
+		DryRun:    *dryRun,
+		Report:    csv.NewWriter(reportFile),
+	}
+	var total importer.Summary
+	for _, path := range fs.Args() {
+		total, err = importFile(path, inputFormat, im)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if !*dryRun {
+		if err := store.SaveToFile(config.Config.FilePath); err != nil {
+			return fmt.Errorf("could not save storage file: %w", err)
+		}
+	}
+
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Printf("read %d, %s %d, invalid %d, conflicts %d; see %s\n",
+		total.Read, verb, total.Imported, total.Invalid, total.Conflicts, *reportPath)
+	return nil
+}
+
+func importFile(path string, format importer.Format, im *importer.Importer) (importer.Summary, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return importer.Summary{}, err
+	}
+	defer file.Close()
+
+	reader, err := importer.NewReader(file, format)
+	if err != nil {
+		return importer.Summary{}, err
+	}
+	return im.Run(context.Background(), reader)
+}
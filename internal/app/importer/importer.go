@@ -0,0 +1,236 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+	"github.com/google/uuid"
+)
+
+const DefaultChunkSize = 500
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+const (
+	ReasonInvalid   = "invalid"
+	ReasonKeyExists = "key_exists"
+	ReasonURLExists = "url_exists"
+	ReasonDuplicate = "duplicate_key_in_input"
+)
+
+var reportHeader = []string{"line", "key", "original_url", "reason", "details"}
+
+// Summary — итог импорта. В режиме DryRun Imported — число записей,
+// которые были бы загружены.
+type Summary struct {
+	Read      int
+	Imported  int
+	Invalid   int
+	Conflicts int
+}
+
+type Importer struct {
+	Store     storage.Storage
+	ChunkSize int
+	DryRun    bool
+	// Report получает по строке CSV на каждую отклонённую запись.
+	Report *csv.Writer
+
+	summary  Summary
+	seenKeys map[string]struct{}
+	// originals в режиме DryRun — short_url по исходным URL хранилища и уже
+	// принятых записей: пробный прогон ничего не сохраняет, поэтому конфликты,
+	// которые при настоящем импорте отклонил бы SaveBatch, ищутся по нему
+	originals     map[string]string
+	chunk         []Record
+	reportStarted bool
+}
+
+// Run загружает записи из r в хранилище пачками через SaveBatch,
+// сохраняя исходные ключи. Один Importer можно запускать на нескольких
+// файлах подряд: итог и проверка повторяющихся ключей общие.
+func (im *Importer) Run(ctx context.Context, r Reader) (Summary, error) {
+	if im.ChunkSize <= 0 {
+		im.ChunkSize = DefaultChunkSize
+	}
+	if im.seenKeys == nil {
+		im.seenKeys = make(map[string]struct{})
+	}
+	if im.DryRun && im.originals == nil {
+		originals, err := loadOriginals(ctx, im.Store)
+		if err != nil {
+			return im.summary, err
+		}
+		im.originals = originals
+	}
+
+	if im.Report != nil && !im.reportStarted {
+		im.reportStarted = true
+		if err := im.Report.Write(reportHeader); err != nil {
+			return im.summary, err
+		}
+	}
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			im.summary.Read++
+			if err := im.reject(Record{Line: rowErr.Line}, ReasonInvalid, rowErr.Err.Error()); err != nil {
+				return im.summary, err
+			}
+			continue
+		}
+		if err != nil {
+			return im.summary, err
+		}
+
+		im.summary.Read++
+		if err := im.add(ctx, record); err != nil {
+			return im.summary, err
+		}
+	}
+
+	if err := im.flush(ctx); err != nil {
+		return im.summary, err
+	}
+
+	if im.Report != nil {
+		im.Report.Flush()
+		return im.summary, im.Report.Error()
+	}
+	return im.summary, nil
+}
+
+func (im *Importer) add(ctx context.Context, record Record) error {
+	if err := validate(record); err != nil {
+		return im.reject(record, ReasonInvalid, err.Error())
+	}
+
+	if _, ok := im.seenKeys[record.Key]; ok {
+		return im.reject(record, ReasonDuplicate, "")
+	}
+	im.seenKeys[record.Key] = struct{}{}
+
+	if existing, _ := im.Store.Get(ctx, record.Key); existing.OriginalURL != "" {
+		return im.reject(record, ReasonKeyExists, existing.OriginalURL)
+	}
+
+	if record.UUID == "" {
+		record.UUID = uuid.New().String()
+	}
+
+	im.chunk = append(im.chunk, record)
+	if len(im.chunk) >= im.ChunkSize {
+		return im.flush(ctx)
+	}
+	return nil
+}
+
+func validate(record Record) error {
+	if !keyPattern.MatchString(record.Key) {
+		return fmt.Errorf("invalid key %q", record.Key)
+	}
+	if err := utils.ValidateURL(record.OriginalURL); err != nil {
+		return fmt.Errorf("%w: %q", err, record.OriginalURL)
+	}
+	if record.UUID != "" {
+		if _, err := uuid.Parse(record.UUID); err != nil {
+			return fmt.Errorf("invalid uuid %q", record.UUID)
+		}
+	}
+	return nil
+}
+
+// flush сохраняет накопленную пачку. Если часть исходных URL уже есть
+// в хранилище, они попадают в отчёт, а пачка сохраняется без них.
+func (im *Importer) flush(ctx context.Context) error {
+	chunk := im.chunk
+	im.chunk = nil
+
+	if im.DryRun {
+		for _, record := range chunk {
+			if shortURL, ok := im.originals[record.OriginalURL]; ok {
+				if err := im.reject(record, ReasonURLExists, shortURL); err != nil {
+					return err
+				}
+				continue
+			}
+			im.originals[record.OriginalURL] = record.Key
+			im.summary.Imported++
+		}
+		return nil
+	}
+
+	items := make([]storage.URLData, len(chunk))
+	records := make(map[string]Record, len(chunk))
+	for i, record := range chunk {
+		items[i] = storage.URLData{
+			UUID:        record.UUID,
+			ShortURL:    record.Key,
+			OriginalURL: record.OriginalURL,
+			UserID:      record.Owner,
+			CreatedAt:   record.CreatedAt,
+		}
+		records[record.UUID] = record
+	}
+
+	saved, conflicts, err := storage.SaveBatchSkippingConflicts(ctx, im.Store, items)
+	for _, conflict := range conflicts {
+		if err := im.reject(records[conflict.UUID], ReasonURLExists, conflict.ShortURL); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not save batch: %w", err)
+	}
+
+	im.summary.Imported += len(saved)
+	return nil
+}
+
+// loadOriginals собирает исходные URL хранилища, участвующие в проверке
+// дубликатов, то есть без ссылок с AllowDuplicate.
+func loadOriginals(ctx context.Context, store storage.Storage) (map[string]string, error) {
+	originals := make(map[string]string)
+	err := storage.Iterate(ctx, store, storage.ListFilter{Limit: DefaultChunkSize}, func(data storage.URLData) error {
+		if _, ok := originals[data.OriginalURL]; !ok && !data.AllowDuplicate {
+			originals[data.OriginalURL] = data.ShortURL
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read existing links: %w", err)
+	}
+	return originals, nil
+}
+
+func (im *Importer) reject(record Record, reason, details string) error {
+	if reason == ReasonInvalid {
+		im.summary.Invalid++
+	} else {
+		im.summary.Conflicts++
+	}
+
+	if im.Report == nil {
+		return nil
+	}
+	return im.Report.Write([]string{
+		strconv.Itoa(record.Line),
+		record.Key,
+		record.OriginalURL,
+		reason,
+		details,
+	})
+}
@@ -0,0 +1,198 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// conflictingStore отклоняет пачки, содержащие уже занятый исходный URL,
+// так же как PostgresStore.
+type conflictingStore struct {
+	storage.Storage
+	existing map[string]string
+	batches  int
+}
+
+func (s *conflictingStore) SaveBatch(ctx context.Context, items []storage.URLData) ([]storage.URLData, error) {
+	s.batches++
+	conflictErr := &storage.ErrURLExists{}
+	for _, item := range items {
+		if shortURL, ok := s.existing[item.OriginalURL]; ok {
+			conflictErr.Conflicts = append(conflictErr.Conflicts, storage.URLData{
+				UUID:        item.UUID,
+				ShortURL:    shortURL,
+				OriginalURL: item.OriginalURL,
+			})
+		}
+	}
+	if len(conflictErr.Conflicts) > 0 {
+		conflictErr.ID = conflictErr.Conflicts[0].UUID
+		conflictErr.ExistingShortURL = conflictErr.Conflicts[0].ShortURL
+		return nil, conflictErr
+	}
+	return s.Storage.SaveBatch(context.Background(), items)
+}
+
+func TestImporter(t *testing.T) {
+	tests := []struct {
+		name           string
+		format         Format
+		input          string
+		dryRun         bool
+		chunkSize      int
+		expected       Summary
+		expectedKeys   []string
+		expectedReport []string
+	}{
+		{
+			name:   "csv with header",
+			format: FormatCSV,
+			input: "short_url,original_url,user_id,created_at\n" +
+				"abc,http://example.com/a,alice,2024-01-02T03:04:05Z\n" +
+				"def,http://example.com/d,,\n",
+			expected:     Summary{Read: 2, Imported: 2},
+			expectedKeys: []string{"abc", "def"},
+		},
+		{
+			name:   "positional csv with invalid rows",
+			format: FormatCSV,
+			input: "abc,http://example.com/a\n" +
+				"bad key,http://example.com/b\n" +
+				"ghi,ftp://example.com/g\n" +
+				"jkl,http://example.com/j,not-a-date\n" +
+				"abc,http://example.com/again\n",
+			expected:     Summary{Read: 5, Imported: 1, Invalid: 3, Conflicts: 1},
+			expectedKeys: []string{"abc"},
+			expectedReport: []string{
+				"2,bad key,http://example.com/b,invalid",
+				"3,ghi,ftp://example.com/g,invalid",
+				"4,,,invalid",
+				"5,abc,http://example.com/again,duplicate_key_in_input",
+			},
+		},
+		{
+			name:   "ndjson with existing key and url",
+			format: FormatNDJSON,
+			input: `{"key":"taken","original_url":"http://example.com/t"}` + "\n" +
+				`{"key":"new1","url":"http://example.com/existing"}` + "\n" +
+				"\n" +
+				`{"key":"new2","long_url":"http://example.com/2","owner":"bob"}` + "\n" +
+				`{broken` + "\n",
+			chunkSize:    1,
+			expected:     Summary{Read: 4, Imported: 1, Invalid: 1, Conflicts: 2},
+			expectedKeys: []string{"new2", "taken"},
+			expectedReport: []string{
+				"1,taken,http://example.com/t,key_exists,http://example.com/old",
+				"2,new1,http://example.com/existing,url_exists,existingKey",
+				"5,,,invalid",
+			},
+		},
+		{
+			name:         "dry run saves nothing",
+			format:       FormatCSV,
+			input:        "abc,http://example.com/a\ndef,http://example.com/d\n",
+			dryRun:       true,
+			expected:     Summary{Read: 2, Imported: 2},
+			expectedKeys: []string{"taken"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &conflictingStore{
+				Storage:  storage.NewInMemoryStore(),
+				existing: map[string]string{"http://example.com/existing": "existingKey"},
+			}
+			if tt.dryRun || tt.format == FormatNDJSON {
+				_, err := store.Save(context.Background(), storage.URLData{ShortURL: "taken", OriginalURL: "http://example.com/old"})
+				assert.NoError(t, err)
+			}
+
+			reader, err := NewReader(strings.NewReader(tt.input), tt.format)
+			assert.NoError(t, err)
+
+			var report bytes.Buffer
+			im := &Importer{Store: store, ChunkSize: tt.chunkSize, DryRun: tt.dryRun, Report: csv.NewWriter(&report)}
+			summary, err := im.Run(context.Background(), reader)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, summary)
+
+			var keys []string
+			err = storage.Iterate(context.Background(), store, storage.ListFilter{}, func(data storage.URLData) error {
+				keys = append(keys, data.ShortURL)
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedKeys, keys)
+
+			lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+			assert.Equal(t, "line,key,original_url,reason,details", lines[0])
+			assert.Len(t, lines, len(tt.expectedReport)+1)
+			for i, expected := range tt.expectedReport {
+				assert.True(t, strings.HasPrefix(lines[i+1], expected), "report line %q should start with %q", lines[i+1], expected)
+			}
+		})
+	}
+}
+
+func TestImporterKeepsMetadata(t *testing.T) {
+	store := storage.NewInMemoryStore()
+	reader, err := NewReader(strings.NewReader(
+		"uuid,short_url,original_url,user_id,created_at\n"+
+			"6f1c2d1e-8f8b-4a53-9a3b-0b2d5f1e9c11,abc,http://example.com/a,alice,2024-01-02T03:04:05Z\n",
+	), FormatCSV)
+	assert.NoError(t, err)
+
+	_, err = (&Importer{Store: store}).Run(context.Background(), reader)
+	assert.NoError(t, err)
+
+	page, err := store.List(context.Background(), storage.ListFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.URLData{{
+		UUID:        "6f1c2d1e-8f8b-4a53-9a3b-0b2d5f1e9c11",
+		ShortURL:    "abc",
+		OriginalURL: "http://example.com/a",
+		UserID:      "alice",
+		CreatedAt:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}}, page)
+}
+
+func TestImporterDryRunMatchesImport(t *testing.T) {
+	input := "short_url,original_url\n" +
+		"new1,http://example.com/1\n" +
+		"new2,http://example.com/stored\n" +
+		"new3,http://example.com/2\n" +
+		"new4,http://example.com/1\n" +
+		"new5,http://example.com/2\n"
+
+	run := func(dryRun bool, chunkSize int) (Summary, string) {
+		store := storage.NewInMemoryStore()
+		_, err := store.Save(context.Background(), storage.URLData{ShortURL: "stored", OriginalURL: "http://example.com/stored"})
+		assert.NoError(t, err)
+
+		reader, err := NewReader(strings.NewReader(input), FormatCSV)
+		assert.NoError(t, err)
+		var report bytes.Buffer
+		im := &Importer{Store: store, ChunkSize: chunkSize, DryRun: dryRun, Report: csv.NewWriter(&report)}
+		summary, err := im.Run(context.Background(), reader)
+		assert.NoError(t, err)
+		return summary, report.String()
+	}
+
+	// повторы внутри пачки и между пачками отклоняются одинаково
+	for _, chunkSize := range []int{2, DefaultChunkSize} {
+		summary, report := run(false, chunkSize)
+		assert.Equal(t, Summary{Read: 5, Imported: 2, Conflicts: 3}, summary)
+
+		drySummary, dryReport := run(true, chunkSize)
+		assert.Equal(t, summary, drySummary, "chunk size %d", chunkSize)
+		assert.Equal(t, report, dryReport, "chunk size %d", chunkSize)
+	}
+}
@@ -0,0 +1,218 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatCSV, FormatNDJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported import format: %s", s)
+	}
+}
+
+// Record — одна строка дампа. Line — номер строки во входном файле,
+// UUID заполнен, только если он есть в дампе (например, в нашем экспорте).
+type Record struct {
+	Line        int
+	UUID        string
+	Key         string
+	OriginalURL string
+	Owner       string
+	CreatedAt   time.Time
+}
+
+// RowError — строка, которую не удалось разобрать; чтение можно продолжать.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+type Reader interface {
+	// Read возвращает очередную запись, *RowError для битой строки
+	// или io.EOF, когда записи закончились.
+	Read() (Record, error)
+}
+
+func NewReader(r io.Reader, format Format) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r), nil
+	case FormatNDJSON:
+		return &ndjsonReader{scanner: bufio.NewScanner(r)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported import format: %s", format)
+	}
+}
+
+// columnAliases сопоставляет названия колонок из дампов разных сервисов
+// с полями Record.
+var columnAliases = map[string]string{
+	"uuid":         "uuid",
+	"id":           "uuid",
+	"key":          "key",
+	"short_url":    "key",
+	"slug":         "key",
+	"code":         "key",
+	"original_url": "original_url",
+	"url":          "original_url",
+	"long_url":     "original_url",
+	"target":       "original_url",
+	"destination":  "original_url",
+	"created_at":   "created_at",
+	"created":      "created_at",
+	"owner":        "owner",
+	"user_id":      "owner",
+	"user":         "owner",
+}
+
+// positionalColumns — порядок колонок в CSV без заголовка.
+var positionalColumns = []string{"key", "original_url", "created_at", "owner"}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []string
+	line    int
+}
+
+func newCSVReader(r io.Reader) *csvReader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	return &csvReader{r: reader}
+}
+
+func (c *csvReader) Read() (Record, error) {
+	for {
+		fields, err := c.r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return Record{}, io.EOF
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return Record{}, &RowError{Line: parseErr.Line, Err: parseErr.Err}
+			}
+			return Record{}, err
+		}
+		c.line, _ = c.r.FieldPos(0)
+
+		if c.columns == nil {
+			if header, ok := parseHeader(fields); ok {
+				c.columns = header
+				continue
+			}
+			c.columns = positionalColumns
+		}
+
+		values := make(map[string]string, len(fields))
+		for i, field := range fields {
+			if i < len(c.columns) && c.columns[i] != "" {
+				values[c.columns[i]] = strings.TrimSpace(field)
+			}
+		}
+		return newRecord(c.line, values)
+	}
+}
+
+// parseHeader распознаёт строку заголовка: она должна содержать
+// колонку с ключом и колонку с исходным URL.
+func parseHeader(fields []string) ([]string, bool) {
+	columns := make([]string, len(fields))
+	var hasKey, hasURL bool
+	for i, field := range fields {
+		column := columnAliases[strings.ToLower(strings.TrimSpace(field))]
+		columns[i] = column
+		hasKey = hasKey || column == "key"
+		hasURL = hasURL || column == "original_url"
+	}
+	return columns, hasKey && hasURL
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonReader) Read() (Record, error) {
+	for n.scanner.Scan() {
+		n.line++
+		line := strings.TrimSpace(n.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var raw map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return Record{}, &RowError{Line: n.line, Err: err}
+		}
+
+		values := make(map[string]string, len(raw))
+		for name, value := range raw {
+			column, ok := columnAliases[strings.ToLower(name)]
+			if !ok || value == nil {
+				continue
+			}
+			values[column] = strings.TrimSpace(fmt.Sprint(value))
+		}
+		return newRecord(n.line, values)
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+func newRecord(line int, values map[string]string) (Record, error) {
+	record := Record{
+		Line:        line,
+		UUID:        values["uuid"],
+		Key:         values["key"],
+		OriginalURL: values["original_url"],
+		Owner:       values["owner"],
+	}
+
+	if createdAt := values["created_at"]; createdAt != "" {
+		t, err := parseTime(createdAt)
+		if err != nil {
+			return Record{}, &RowError{Line: line, Err: err}
+		}
+		record.CreatedAt = t
+	}
+
+	return record, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, time.DateTime, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid created_at %q", s)
+}
@@ -1,13 +1,121 @@
+// Package logger настраивает общий структурированный логгер сервиса
+// и передаёт его вместе с идентификатором запроса через context.
 package logger
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+
+	// RequestIDHeader — заголовок с идентификатором запроса
+	RequestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLen ограничивает длину принятого от клиента идентификатора
+	maxRequestIDLen = 128
+
+	samplingInitial    = 100
+	samplingThereafter = 100
+)
+
+// Options — настройки логгера. Level — минимальный уровень записей,
+// Format — json или console, Sampling включает прореживание одинаковых
+// записей: в секунду пишутся первые 100, затем каждая сотая.
+type Options struct {
+	Level    string
+	Format   string
+	Sampling bool
+}
+
+// New создаёт логгер по opts.
+func New(opts Options) (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := zap.NewProductionConfig()
+	switch opts.Format {
+	case FormatJSON, "":
+	case FormatConsole:
+		cfg.Encoding = FormatConsole
+		cfg.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+	cfg.Level = zap.NewAtomicLevelAt(level)
+	cfg.Sampling = nil
+	if opts.Sampling {
+		cfg.Sampling = &zap.SamplingConfig{Initial: samplingInitial, Thereafter: samplingThereafter}
+	}
+
+	return cfg.Build()
+}
+
+type (
+	loggerKey    struct{}
+	requestIDKey struct{}
+	fieldsKey    struct{}
+)
+
+// WithContext возвращает контекст, из которого FromContext вернёт l.
+func WithContext(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext возвращает логгер запроса или общий логгер zap.L(),
+// если в ctx логгера нет.
+func FromContext(ctx context.Context) *zap.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return l
+	}
+	return zap.L()
+}
+
+// With добавляет поля к логгеру в ctx. Внутри HTTP-запроса поля попадают
+// и в итоговую запись о запросе.
+func With(ctx context.Context, fields ...zap.Field) context.Context {
+	if rf, ok := ctx.Value(fieldsKey{}).(*requestFields); ok {
+		rf.add(fields)
+	}
+	return WithContext(ctx, FromContext(ctx).With(fields...))
+}
+
+// RequestIDFromContext возвращает идентификатор текущего запроса.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestFields собирает поля, добавленные обработчиками запроса,
+// для итоговой записи о запросе.
+type requestFields struct {
+	mu     sync.Mutex
+	fields []zap.Field
+}
+
+func (rf *requestFields) add(fields []zap.Field) {
+	rf.mu.Lock()
+	rf.fields = append(rf.fields, fields...)
+	rf.mu.Unlock()
+}
+
+func (rf *requestFields) list() []zap.Field {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return append([]zap.Field(nil), rf.fields...)
+}
+
 // обёртка для ResponseWriter
 type loggingResponseWriter struct {
 	http.ResponseWriter
@@ -26,37 +134,59 @@ func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
-func initLogger() *zap.SugaredLogger {
-	logger, err := zap.NewProduction()
-
-	if err != nil {
-		log.Fatalf("Не удалось инициализировать логгер: %v", err)
-	}
-	return logger.Sugar()
-}
-
-func LoggingMiddleware() func(http.Handler) http.Handler {
-	sugar := initLogger()
+// LoggingMiddleware присваивает запросу идентификатор из X-Request-ID
+// или новый, возвращает его в ответе, кладёт в контекст логгер с request_id
+// и после ответа пишет запись о запросе.
+func LoggingMiddleware(l *zap.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
-			uri := r.RequestURI
-			method := r.Method
+			requestID := r.Header.Get(RequestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = uuid.New().String()
+			}
+			w.Header().Set(RequestIDHeader, requestID)
+
+			reqLogger := l.With(zap.String("request_id", requestID))
+			rf := &requestFields{}
+			ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+			ctx = context.WithValue(ctx, fieldsKey{}, rf)
+			ctx = WithContext(ctx, reqLogger)
+			r = r.WithContext(ctx)
 
 			lw := &loggingResponseWriter{w, http.StatusOK, 0}
 
 			next.ServeHTTP(lw, r)
 
-			duration := time.Since(start)
+			fields := []zap.Field{
+				zap.String("method", r.Method),
+				zap.String("uri", r.RequestURI),
+				zap.Int("status", lw.statusCode),
+				zap.Int("content_length", lw.contentLength),
+				zap.Duration("duration", time.Since(start)),
+			}
+			fields = append(fields, rf.list()...)
 
-			sugar.Infow("HTTP request",
-				"method", method,
-				"uri", uri,
-				"status", lw.statusCode,
-				"content_length", lw.contentLength,
-				"duration", duration,
-			)
+			if lw.statusCode >= http.StatusInternalServerError {
+				reqLogger.Error("HTTP request", fields...)
+				return
+			}
+			reqLogger.Info("HTTP request", fields...)
 		})
 	}
 }
+
+// validRequestID принимает непустой идентификатор разумной длины
+// из видимых ASCII-символов, чтобы клиент не мог испортить записи лога.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
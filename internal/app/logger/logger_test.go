@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "json", opts: Options{Level: "info", Format: FormatJSON, Sampling: true}},
+		{name: "console", opts: Options{Level: "debug", Format: FormatConsole}},
+		{name: "unknown level", opts: Options{Level: "verbose", Format: FormatJSON}, wantErr: true},
+		{name: "unknown format", opts: Options{Level: "info", Format: "xml"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := New(tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, l)
+		})
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	var requestID string
+	handler := LoggingMiddleware(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = RequestIDFromContext(r.Context())
+		ctx := With(r.Context(), zap.String("user_id", "user-1"))
+		FromContext(ctx).Debug("inside handler")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name      string
+		header    string
+		propagate bool
+	}{
+		{name: "generated", header: ""},
+		{name: "propagated", header: "req-42", propagate: true},
+		{name: "invalid", header: "bad id\nwith newline"},
+		{name: "too long", header: strings.Repeat("a", maxRequestIDLen+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.TakeAll()
+
+			req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.NotEmpty(t, requestID)
+			assert.Equal(t, requestID, rec.Header().Get(RequestIDHeader))
+			if tt.propagate {
+				assert.Equal(t, tt.header, requestID)
+			} else {
+				assert.NotEqual(t, tt.header, requestID)
+			}
+
+			entries := logs.AllUntimed()
+			require.Len(t, entries, 2)
+			for _, entry := range entries {
+				fields := entry.ContextMap()
+				assert.Equal(t, requestID, fields["request_id"])
+				assert.Equal(t, "user-1", fields["user_id"])
+			}
+
+			access := entries[1].ContextMap()
+			assert.Equal(t, "HTTP request", entries[1].Message)
+			assert.Equal(t, int64(http.StatusTeapot), access["status"])
+			assert.Equal(t, "/abc", access["uri"])
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/condratf/shortner/internal/app/migrator"
+	"github.com/condratf/shortner/internal/app/storage"
+)
+
+// storeLocation — хранилище, открытое по адресу из командной строки.
+type storeLocation struct {
+	store storage.Storage
+	// persist сбрасывает изменения на диск; для Postgres не нужен.
+	persist func() error
+	close   func() error
+}
+
+// openLocation открывает хранилище по адресу вида file:PATH, bolt:PATH или postgres://...
+func openLocation(location string) (*storeLocation, error) {
+	switch {
+	case strings.HasPrefix(location, "file:"):
+		path := strings.TrimPrefix(location, "file:")
+		if path == "" {
+			return nil, errors.New("empty file path")
+		}
+		store := storage.NewInMemoryStore()
+		if err := store.LoadFromFile(path); err != nil {
+			return nil, fmt.Errorf("could not load %s: %w", path, err)
+		}
+		return &storeLocation{
+			store:   store,
+			persist: func() error { return store.SaveToFile(path) },
+			close:   func() error { return nil },
+		}, nil
+
+	case strings.HasPrefix(location, "bolt:"):
+		path := strings.TrimPrefix(location, "bolt:")
+		if path == "" {
+			return nil, errors.New("empty bolt path")
+		}
+		store, err := storage.NewBoltStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return &storeLocation{
+			store:   store,
+			persist: func() error { return nil },
+			close:   store.(io.Closer).Close,
+		}, nil
+
+	case strings.HasPrefix(location, "postgres://"), strings.HasPrefix(location, "postgresql://"):
+		conn, err := sql.Open("postgres", location)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to the database: %w", err)
+		}
+		if err := conn.Ping(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not ping the database: %w", err)
+		}
+		store, err := storage.NewPostgresStore(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &storeLocation{
+			store:   store,
+			persist: func() error { return nil },
+			close:   conn.Close,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported storage location %q: expected file:PATH, bolt:PATH or postgres://...", location)
+	}
+}
+
+// MigrateStorage переносит все ссылки с историей изменений и ключи доступа
+// между хранилищами:
+//
+//	shortener migrate-storage --from file:./shortener.json --to postgres://... [--batch N] [--checkpoint FILE]
+func MigrateStorage(args []string) error {
+	fs := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	from := fs.String("from", "", "Source storage: file:PATH, bolt:PATH or postgres://...")
+	to := fs.String("to", "", "Target storage: file:PATH, bolt:PATH or postgres://...")
+	batchSize := fs.Int("batch", migrator.DefaultBatchSize, "Number of records copied per batch")
+	checkpointPath := fs.String("checkpoint", "migrate-storage.checkpoint", "File for resumable progress")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return errors.New("both --from and --to are required")
+	}
+	if *from == *to {
+		return errors.New("source and target are the same")
+	}
+
+	log, err := initLogger()
+	if err != nil {
+		return err
+	}
+	defer log.Sync()
+	sugar := log.Sugar()
+
+	source, err := openLocation(*from)
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	defer source.close()
+
+	target, err := openLocation(*to)
+	if err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	defer target.close()
+
+	m := &migrator.Migrator{
+		Source:         source.store,
+		Target:         target.store,
+		Persist:        target.persist,
+		BatchSize:      *batchSize,
+		CheckpointPath: *checkpointPath,
+		From:           *from,
+		To:             *to,
+		Logf:           sugar.Infof,
+	}
+
+	cp, err := m.Run(context.Background())
+	if err != nil {
+		return err
+	}
+	sugar.Infof("migration finished: %d copied, %d skipped, %d api keys copied", cp.Copied, cp.Skipped, cp.APIKeys)
+
+	sourceDigest, targetDigest, err := m.Verify(context.Background())
+	if err != nil {
+		if cp.Skipped > 0 {
+			return fmt.Errorf("%w; %d records were skipped as duplicate original URLs", err, cp.Skipped)
+		}
+		return err
+	}
+	sugar.Infof("verified %d records, checksum %s (target: %d, %s)",
+		sourceDigest.Count, sourceDigest.Checksum, targetDigest.Count, targetDigest.Checksum)
+
+	return m.RemoveCheckpoint()
+}
@@ -0,0 +1,303 @@
+package migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/condratf/shortner/internal/app/storage"
+)
+
+const DefaultBatchSize = 500
+
+// Checkpoint — состояние миграции, сохраняемое после каждой пачки.
+// After — short_url последней перенесённой записи источника,
+// APIKeys — число ключей доступа, перенесённых после всех ссылок.
+type Checkpoint struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	After   string `json:"after"`
+	Copied  int    `json:"copied"`
+	Skipped int    `json:"skipped"`
+	APIKeys int    `json:"api_keys,omitempty"`
+}
+
+type Migrator struct {
+	Source storage.Storage
+	Target storage.Storage
+	// Persist вызывается после каждой пачки перед записью чекпоинта,
+	// например чтобы сбросить файловое хранилище на диск.
+	Persist        func() error
+	BatchSize      int
+	CheckpointPath string
+	// From и To описывают источник и приёмник; чекпоинт другой пары не используется.
+	From string
+	To   string
+	// Logf получает сообщения о ходе миграции.
+	Logf func(format string, args ...interface{})
+}
+
+// Run переносит все записи из Source в Target пачками вместе с историей
+// их изменений, а затем ключи доступа, продолжая с сохранённого
+// чекпоинта, если он есть.
+func (m *Migrator) Run(ctx context.Context) (Checkpoint, error) {
+	if m.BatchSize <= 0 {
+		m.BatchSize = DefaultBatchSize
+	}
+
+	cp, resumed, err := m.loadCheckpoint()
+	if err != nil {
+		return cp, err
+	}
+	if resumed {
+		m.logf("resuming after %q: %d copied, %d skipped", cp.After, cp.Copied, cp.Skipped)
+	}
+
+	for {
+		page, err := m.Source.List(ctx, storage.ListFilter{After: cp.After, Limit: m.BatchSize})
+		if err != nil {
+			return cp, fmt.Errorf("could not read source: %w", err)
+		}
+		if len(page) == 0 {
+			return cp, m.copyAPIKeys(ctx, &cp)
+		}
+
+		items := make([]storage.URLData, 0, len(page))
+		// copied — ключи ссылок, которые есть в приёмнике и чья история переносится
+		var copied []string
+		for _, data := range page {
+			// после сбоя между сохранением пачки и записью чекпоинта
+			// часть записей уже может быть в приёмнике
+			if resumed {
+				if existing, _ := m.Target.Get(ctx, data.ShortURL); existing.OriginalURL == data.OriginalURL {
+					cp.Copied++
+					copied = append(copied, data.ShortURL)
+					continue
+				}
+			}
+			items = append(items, data)
+		}
+		resumed = false
+
+		saved, skipped, err := storage.SaveBatchSkippingConflicts(ctx, m.Target, items)
+		if err != nil {
+			return cp, fmt.Errorf("could not write target: %w", err)
+		}
+		for _, conflict := range skipped {
+			m.logf("skipped %s: original URL %s is already stored as %s", conflict.UUID, conflict.OriginalURL, conflict.ShortURL)
+		}
+		for _, data := range saved {
+			copied = append(copied, data.ShortURL)
+		}
+		if err := m.copyHistory(ctx, copied); err != nil {
+			return cp, err
+		}
+
+		if m.Persist != nil {
+			if err := m.Persist(); err != nil {
+				return cp, fmt.Errorf("could not persist target: %w", err)
+			}
+		}
+
+		cp.After = page[len(page)-1].ShortURL
+		cp.Copied += len(saved)
+		cp.Skipped += len(skipped)
+		if err := m.saveCheckpoint(cp); err != nil {
+			return cp, err
+		}
+		m.logf("copied %d, skipped %d", cp.Copied, cp.Skipped)
+	}
+}
+
+// copyHistory переносит историю изменений ссылок keys. SetHistory заменяет
+// историю целиком, поэтому повтор после сбоя её не дублирует.
+func (m *Migrator) copyHistory(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		entries, err := m.Source.History(ctx, key)
+		if err != nil {
+			return fmt.Errorf("could not read source history of %s: %w", key, err)
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		if err := m.Target.SetHistory(ctx, key, entries); err != nil {
+			return fmt.Errorf("could not write target history of %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// copyAPIKeys переносит ключи доступа, которых ещё нет в приёмнике.
+// Ключей немного, поэтому они переносятся одним проходом после ссылок.
+func (m *Migrator) copyAPIKeys(ctx context.Context, cp *Checkpoint) error {
+	keys, err := m.Source.AllAPIKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("could not read source api keys: %w", err)
+	}
+
+	copied := 0
+	for _, key := range keys {
+		_, err := m.Target.GetAPIKey(ctx, key.Hash)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return fmt.Errorf("could not read target api key %s: %w", key.ID, err)
+		}
+		if err := m.Target.SaveAPIKey(ctx, key); err != nil {
+			return fmt.Errorf("could not write target api key %s: %w", key.ID, err)
+		}
+		copied++
+	}
+	if copied == 0 {
+		return nil
+	}
+
+	if m.Persist != nil {
+		if err := m.Persist(); err != nil {
+			return fmt.Errorf("could not persist target: %w", err)
+		}
+	}
+	cp.APIKeys += copied
+	if err := m.saveCheckpoint(*cp); err != nil {
+		return err
+	}
+	m.logf("copied %d api keys", copied)
+	return nil
+}
+
+func (m *Migrator) loadCheckpoint() (Checkpoint, bool, error) {
+	cp := Checkpoint{From: m.From, To: m.To}
+	if m.CheckpointPath == "" {
+		return cp, false, nil
+	}
+
+	data, err := os.ReadFile(m.CheckpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, false, nil
+	}
+	if err != nil {
+		return cp, false, fmt.Errorf("could not read checkpoint: %w", err)
+	}
+
+	var saved Checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return cp, false, fmt.Errorf("could not parse checkpoint: %w", err)
+	}
+	if saved.From != m.From || saved.To != m.To {
+		return cp, false, fmt.Errorf("checkpoint %s belongs to migration %s -> %s", m.CheckpointPath, saved.From, saved.To)
+	}
+	return saved, true, nil
+}
+
+func (m *Migrator) saveCheckpoint(cp Checkpoint) error {
+	if m.CheckpointPath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	// пишем во временный файл и переименовываем, чтобы чекпоинт не оказался обрезанным
+	tmp := m.CheckpointPath + ".tmp"
+	if err := os.WriteFile(tmp, data, storage.FilePermUserReadWrite); err != nil {
+		return fmt.Errorf("could not write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, m.CheckpointPath); err != nil {
+		return fmt.Errorf("could not write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// RemoveCheckpoint удаляет чекпоинт завершённой миграции.
+func (m *Migrator) RemoveCheckpoint() error {
+	if m.CheckpointPath == "" {
+		return nil
+	}
+	if err := os.Remove(m.CheckpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (m *Migrator) logf(format string, args ...interface{}) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
+
+// Digest — число записей хранилища и их контрольная сумма.
+type Digest struct {
+	Count    int
+	Checksum string
+}
+
+// Checksum обходит хранилище и считает контрольную сумму по UUID, ключу,
+// исходному URL и владельцу каждой записи. Хэши записей складываются
+// через XOR, поэтому сумма не зависит от порядка обхода, который
+// в разных хранилищах может отличаться (например, из-за collation в Postgres).
+func Checksum(ctx context.Context, s storage.Storage) (Digest, error) {
+	var d digester
+	err := storage.Iterate(ctx, s, storage.ListFilter{Limit: DefaultBatchSize}, func(data storage.URLData) error {
+		d.add(data)
+		return nil
+	})
+	return d.digest(), err
+}
+
+type digester struct {
+	count int
+	sum   [sha256.Size]byte
+}
+
+func (d *digester) add(data storage.URLData) {
+	h := sha256.New()
+	for _, field := range []string{data.UUID, data.ShortURL, data.OriginalURL, data.UserID} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	for i, b := range h.Sum(nil) {
+		d.sum[i] ^= b
+	}
+	d.count++
+}
+
+func (d *digester) digest() Digest {
+	return Digest{Count: d.count, Checksum: hex.EncodeToString(d.sum[:])}
+}
+
+// Verify сравнивает записи источника с записями приёмника под теми же ключами.
+// Остальные ссылки приёмника, например созданные до миграции, не учитываются.
+func (m *Migrator) Verify(ctx context.Context) (source, target Digest, err error) {
+	var sourceDigest, targetDigest digester
+	err = storage.Iterate(ctx, m.Source, storage.ListFilter{Limit: DefaultBatchSize}, func(data storage.URLData) error {
+		sourceDigest.add(data)
+
+		migrated, err := m.Target.Get(ctx, data.ShortURL)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read target: %w", err)
+		}
+		targetDigest.add(migrated)
+		return nil
+	})
+	source, target = sourceDigest.digest(), targetDigest.digest()
+	if err != nil {
+		return source, target, fmt.Errorf("could not checksum: %w", err)
+	}
+	if source != target {
+		return source, target, fmt.Errorf(
+			"verification failed: source has %d records (%s), target has %d of them (%s)",
+			source.Count, source.Checksum, target.Count, target.Checksum,
+		)
+	}
+	return source, target, nil
+}
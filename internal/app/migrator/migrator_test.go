@@ -0,0 +1,134 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStore падает на заданной по счёту пачке, имитируя обрыв миграции.
+type failingStore struct {
+	storage.Storage
+	failOn  int
+	batches int
+}
+
+func (s *failingStore) SaveBatch(ctx context.Context, items []storage.URLData) ([]storage.URLData, error) {
+	s.batches++
+	if s.batches == s.failOn {
+		return nil, errors.New("connection reset")
+	}
+	return s.Storage.SaveBatch(context.Background(), items)
+}
+
+func TestMigrator(t *testing.T) {
+	source := storage.NewInMemoryStore()
+	for i := 0; i < 7; i++ {
+		_, err := source.Save(context.Background(), storage.URLData{
+			UUID:        uuid.New().String(),
+			ShortURL:    fmt.Sprintf("key%d", i),
+			OriginalURL: fmt.Sprintf("http://example.com/%d", i),
+			UserID:      "user",
+		})
+		assert.NoError(t, err)
+	}
+	changed := "http://example.com/changed"
+	_, err := source.Update(context.Background(), "key5", "user", storage.URLUpdate{OriginalURL: &changed})
+	assert.NoError(t, err)
+	history, err := source.History(context.Background(), "key5")
+	assert.NoError(t, err)
+	apiKey := storage.APIKey{ID: uuid.New().String(), UserID: "user", Prefix: "sk_1", Hash: "hash-1", Scopes: []string{"read"}}
+	assert.NoError(t, source.SaveAPIKey(context.Background(), apiKey))
+
+	// ссылка, созданная в приёмнике до миграции, не мешает проверке
+	targetStore := storage.NewInMemoryStore()
+	_, err = targetStore.Save(context.Background(), storage.URLData{UUID: uuid.New().String(), ShortURL: "own", OriginalURL: "http://example.com/own"})
+	assert.NoError(t, err)
+	target := &failingStore{Storage: targetStore, failOn: 3}
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint")
+	newMigrator := func() *Migrator {
+		return &Migrator{
+			Source:         source,
+			Target:         target,
+			BatchSize:      2,
+			CheckpointPath: checkpointPath,
+			From:           "file:source.json",
+			To:             "file:target.json",
+		}
+	}
+
+	cp, err := newMigrator().Run(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, "key3", cp.After)
+	assert.Equal(t, 4, cp.Copied)
+
+	_, _, err = newMigrator().Verify(context.Background())
+	assert.Error(t, err, "verification must fail for a partial migration")
+
+	cp, err = newMigrator().Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Checkpoint{From: "file:source.json", To: "file:target.json", After: "key6", Copied: 7, APIKeys: 1}, cp)
+
+	sourceDigest, targetDigest, err := newMigrator().Verify(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 7, sourceDigest.Count)
+	assert.Equal(t, sourceDigest, targetDigest)
+
+	migratedHistory, err := target.History(context.Background(), "key5")
+	assert.NoError(t, err)
+	assert.Equal(t, history, migratedHistory)
+	migratedKey, err := target.GetAPIKey(context.Background(), "hash-1")
+	assert.NoError(t, err)
+	assert.Equal(t, apiKey, migratedKey)
+
+	// Case: Repeated run copies nothing twice
+	cp, err = newMigrator().Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cp.APIKeys)
+	migratedHistory, err = target.History(context.Background(), "key5")
+	assert.NoError(t, err)
+	assert.Len(t, migratedHistory, 1)
+
+	m := newMigrator()
+	m.From = "file:other.json"
+	_, err = m.Run(context.Background())
+	assert.Error(t, err, "checkpoint of another migration must not be reused")
+
+	assert.NoError(t, newMigrator().RemoveCheckpoint())
+	_, err = os.Stat(checkpointPath)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestChecksumIgnoresOrder(t *testing.T) {
+	records := []storage.URLData{
+		{UUID: "1", ShortURL: "b", OriginalURL: "http://example.com/b"},
+		{UUID: "2", ShortURL: "a", OriginalURL: "http://example.com/a"},
+	}
+
+	first, second := storage.NewInMemoryStore(), storage.NewInMemoryStore()
+	for i := range records {
+		_, err := first.Save(context.Background(), records[i])
+		assert.NoError(t, err)
+		_, err = second.Save(context.Background(), records[len(records)-1-i])
+		assert.NoError(t, err)
+	}
+
+	firstDigest, err := Checksum(context.Background(), first)
+	assert.NoError(t, err)
+	secondDigest, err := Checksum(context.Background(), second)
+	assert.NoError(t, err)
+	assert.Equal(t, firstDigest, secondDigest)
+
+	_, err = second.Save(context.Background(), storage.URLData{UUID: "3", ShortURL: "c", OriginalURL: "http://example.com/c"})
+	assert.NoError(t, err)
+	secondDigest, err = Checksum(context.Background(), second)
+	assert.NoError(t, err)
+	assert.NotEqual(t, firstDigest, secondDigest)
+}
@@ -15,3 +15,16 @@ type BatchItem struct {
 	ShortURL      string `json:"short_url"`
 	OriginalURL   string `json:"original_url"`
 }
+
+// LinkOptions — необязательные параметры новой ссылки.
+// AllowDuplicate создаёт отдельный ключ даже для уже сокращённого URL,
+// например чтобы различать переходы по разным рекламным кампаниям.
+// Password закрывает ссылку: перед редиректом нужно ввести пароль.
+// MaxClicks делает ссылку одноразовой или N-разовой.
+type LinkOptions struct {
+	AllowDuplicate bool              `json:"allow_duplicate"`
+	Tag            string            `json:"tag"`
+	Metadata       map[string]string `json:"metadata"`
+	Password       string            `json:"password"`
+	MaxClicks      int               `json:"max_clicks"`
+}
@@ -0,0 +1,275 @@
+// Package problem описывает ошибки API в формате RFC 7807
+// (application/problem+json) и сопоставляет им ошибки предметной области.
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/condratf/shortner/internal/app/logger"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	ContentType = "application/problem+json"
+
+	// typePrefix — префикс поля type; за ним следует код ошибки
+	typePrefix = "urn:shortener:problem:"
+)
+
+// Code — стабильный машиночитаемый код ошибки. Коды не меняются между
+// версиями, в отличие от текста в поле detail.
+type Code string
+
+const (
+	CodeBadRequest           Code = "bad_request"
+	CodeInvalidURL           Code = "invalid_url"
+	CodeUnauthorized         Code = "unauthorized"
+	CodeForbidden            Code = "forbidden"
+	CodeNotFound             Code = "not_found"
+	CodeMethodNotAllowed     Code = "method_not_allowed"
+	CodeURLExists            Code = "url_exists"
+	CodeLinkExpired          Code = "link_expired"
+	CodeClickLimitReached    Code = "click_limit_reached"
+	CodeBodyTooLarge         Code = "body_too_large"
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+	CodeRateLimited          Code = "rate_limited"
+	CodeLinkDisabled         Code = "link_disabled"
+	CodeInternal             Code = "internal"
+)
+
+// Ошибки, у которых нет своего источника в других пакетах.
+var (
+	ErrBadRequest           = errors.New("bad request")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+	ErrNotFound             = errors.New("not found")
+	ErrMethodNotAllowed     = errors.New("method not allowed")
+	ErrLinkExpired          = errors.New("link has expired")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrRateLimited          = errors.New("too many requests")
+	ErrLinkDisabled         = errors.New("link is disabled")
+	ErrInternal             = errors.New("internal server error")
+)
+
+// kinds сопоставляет ошибкам статус и код ответа; побеждает первое совпадение.
+var kinds = []struct {
+	err    error
+	status int
+	code   Code
+}{
+	{ErrBadRequest, http.StatusBadRequest, CodeBadRequest},
+	{utils.ErrInvalidURL, http.StatusBadRequest, CodeInvalidURL},
+	{ErrUnauthorized, http.StatusUnauthorized, CodeUnauthorized},
+	{ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{storage.ErrForbidden, http.StatusForbidden, CodeForbidden},
+	{ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{storage.ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{storage.ErrAPIKeyNotFound, http.StatusNotFound, CodeNotFound},
+	{ErrMethodNotAllowed, http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+	{&storage.ErrURLExists{}, http.StatusConflict, CodeURLExists},
+	{ErrLinkExpired, http.StatusGone, CodeLinkExpired},
+	{storage.ErrClickLimitReached, http.StatusGone, CodeClickLimitReached},
+	{ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+	{ErrRateLimited, http.StatusTooManyRequests, CodeRateLimited},
+	{ErrLinkDisabled, http.StatusUnavailableForLegalReasons, CodeLinkDisabled},
+	{ErrInternal, http.StatusInternalServerError, CodeInternal},
+}
+
+// Error — ошибка вида Kind с текстом Detail для клиента. Cause, если
+// задана, попадает только в лог.
+type Error struct {
+	Kind   error
+	Detail string
+	Cause  error
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Detail + ": " + e.Cause.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Cause != nil {
+		return []error{e.Kind, e.Cause}
+	}
+	return []error{e.Kind}
+}
+
+// New возвращает ошибку вида kind с текстом detail.
+func New(kind error, detail string) error {
+	return &Error{Kind: kind, Detail: detail}
+}
+
+// Newf — New с форматированием текста.
+func Newf(kind error, format string, args ...interface{}) error {
+	return New(kind, fmt.Sprintf(format, args...))
+}
+
+// Internal возвращает ошибку сервера: клиент видит detail, а cause пишется в лог.
+func Internal(cause error, detail string) error {
+	return &Error{Kind: ErrInternal, Detail: detail, Cause: cause}
+}
+
+// Problem — тело ответа об ошибке по RFC 7807. Extensions добавляются
+// в объект рядом со стандартными полями.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Code       Code
+	Extensions map[string]interface{}
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{}, len(p.Extensions)+6)
+	for k, v := range p.Extensions {
+		fields[k] = v
+	}
+	fields["type"] = p.Type
+	fields["title"] = p.Title
+	fields["status"] = p.Status
+	fields["code"] = p.Code
+	if p.Detail != "" {
+		fields["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		fields["instance"] = p.Instance
+	}
+	return json.Marshal(fields)
+}
+
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	known := map[string]interface{}{
+		"type":     &p.Type,
+		"title":    &p.Title,
+		"status":   &p.Status,
+		"detail":   &p.Detail,
+		"instance": &p.Instance,
+		"code":     &p.Code,
+	}
+	for k, raw := range fields {
+		if dst, ok := known[k]; ok {
+			if err := json.Unmarshal(raw, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]interface{})
+		}
+		p.Extensions[k] = v
+	}
+	return nil
+}
+
+func newProblem(status int, code Code) Problem {
+	return Problem{
+		Type:   typePrefix + string(code),
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+	}
+}
+
+// FromError строит ответ по ошибке. Неизвестные ошибки становятся 500,
+// и их текст клиенту не показывается.
+func FromError(err error) Problem {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		p := newProblem(http.StatusRequestEntityTooLarge, CodeBodyTooLarge)
+		p.Detail = fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)
+		return p
+	}
+
+	kind, detail := err, ""
+	var e *Error
+	if errors.As(err, &e) {
+		kind, detail = e.Kind, e.Detail
+	}
+
+	p := newProblem(http.StatusInternalServerError, CodeInternal)
+	for _, k := range kinds {
+		if errors.Is(kind, k.err) {
+			p = newProblem(k.status, k.code)
+			break
+		}
+	}
+	switch {
+	case detail != "":
+		p.Detail = detail
+	case p.Status < http.StatusInternalServerError:
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+type plainTextKey struct{}
+
+// WithPlainText отмечает запрос, ошибки которого отправляются простым
+// текстом: так отвечают эндпоинты, принимающие text/plain.
+func WithPlainText(ctx context.Context) context.Context {
+	return context.WithValue(ctx, plainTextKey{}, true)
+}
+
+func plainText(ctx context.Context) bool {
+	v, _ := ctx.Value(plainTextKey{}).(bool)
+	return v
+}
+
+// Write отвечает на запрос ошибкой err. Ошибки сервера пишутся в лог
+// запроса вместе с причиной.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	p := FromError(err)
+	if p.Status >= http.StatusInternalServerError {
+		logger.FromContext(r.Context()).Error("request failed", zap.Error(err))
+	}
+	Respond(w, r, p)
+}
+
+// Respond отправляет p как application/problem+json или, для запросов
+// с WithPlainText, как текст из поля detail.
+func Respond(w http.ResponseWriter, r *http.Request, p Problem) {
+	if plainText(r.Context()) {
+		detail := p.Detail
+		if detail == "" {
+			detail = p.Title
+		}
+		http.Error(w, detail, p.Status)
+		return
+	}
+
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if id := logger.RequestIDFromContext(r.Context()); id != "" {
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]interface{})
+		}
+		p.Extensions["request_id"] = id
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", ContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,87 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   Code
+		wantDetail string
+	}{
+		{name: "bad request", err: New(ErrBadRequest, "url is required"), wantStatus: http.StatusBadRequest, wantCode: CodeBadRequest, wantDetail: "url is required"},
+		{name: "invalid url", err: fmt.Errorf("shorten: %w", utils.ErrInvalidURL), wantStatus: http.StatusBadRequest, wantCode: CodeInvalidURL, wantDetail: "shorten: " + utils.ErrInvalidURL.Error()},
+		{name: "not found", err: storage.ErrNotFound, wantStatus: http.StatusNotFound, wantCode: CodeNotFound, wantDetail: storage.ErrNotFound.Error()},
+		{name: "exists", err: &storage.ErrURLExists{ExistingShortURL: "abc"}, wantStatus: http.StatusConflict, wantCode: CodeURLExists},
+		{name: "click limit", err: storage.ErrClickLimitReached, wantStatus: http.StatusGone, wantCode: CodeClickLimitReached},
+		{name: "rate limited", err: ErrRateLimited, wantStatus: http.StatusTooManyRequests, wantCode: CodeRateLimited},
+		{name: "body too large", err: &http.MaxBytesError{Limit: 10}, wantStatus: http.StatusRequestEntityTooLarge, wantCode: CodeBodyTooLarge, wantDetail: "request body exceeds 10 bytes"},
+		// причина ошибки сервера клиенту не показывается
+		{name: "internal", err: Internal(errors.New("pq: connection refused"), "could not store URL"), wantStatus: http.StatusInternalServerError, wantCode: CodeInternal, wantDetail: "could not store URL"},
+		{name: "unknown", err: errors.New("pq: connection refused"), wantStatus: http.StatusInternalServerError, wantCode: CodeInternal},
+		// вид ошибки важнее причины
+		{name: "kind wins", err: &Error{Kind: ErrForbidden, Detail: "no", Cause: storage.ErrNotFound}, wantStatus: http.StatusForbidden, wantCode: CodeForbidden, wantDetail: "no"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := FromError(tt.err)
+			assert.Equal(t, tt.wantStatus, p.Status)
+			assert.Equal(t, tt.wantCode, p.Code)
+			assert.Equal(t, typePrefix+string(tt.wantCode), p.Type)
+			assert.Equal(t, http.StatusText(tt.wantStatus), p.Title)
+			if tt.wantDetail != "" || tt.wantStatus >= http.StatusInternalServerError {
+				assert.Equal(t, tt.wantDetail, p.Detail)
+			}
+		})
+	}
+}
+
+func TestRespond(t *testing.T) {
+	p := FromError(&storage.ErrURLExists{ExistingShortURL: "abc"})
+	p.Extensions = map[string]interface{}{"result": "http://localhost:8080/abc"}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+	rec := httptest.NewRecorder()
+	Respond(rec, req, p)
+
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "urn:shortener:problem:url_exists", body["type"])
+	assert.Equal(t, float64(http.StatusConflict), body["status"])
+	assert.Equal(t, "url_exists", body["code"])
+	assert.Equal(t, "/api/shorten", body["instance"])
+	assert.Equal(t, "http://localhost:8080/abc", body["result"])
+
+	var decoded Problem
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &decoded))
+	assert.Equal(t, CodeURLExists, decoded.Code)
+	assert.Equal(t, "http://localhost:8080/abc", decoded.Extensions["result"])
+}
+
+func TestWrite_PlainText(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req = req.WithContext(WithPlainText(req.Context()))
+	rec := httptest.NewRecorder()
+	Write(rec, req, New(ErrBadRequest, "could not read request body"))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	assert.Equal(t, "could not read request body\n", rec.Body.String())
+}
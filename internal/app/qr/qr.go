@@ -0,0 +1,159 @@
+// Package qr рисует QR-коды коротких ссылок в PNG и SVG.
+package qr
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+type Format string
+
+const (
+	FormatPNG Format = "png"
+	FormatSVG Format = "svg"
+)
+
+const (
+	DefaultSize      = 256
+	MaxSize          = 2048
+	DefaultQuietZone = 4
+	MaxQuietZone     = 16
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown QR format")
+	ErrUnknownLevel  = errors.New("unknown error correction level")
+	ErrInvalidSize   = errors.New("invalid QR size")
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case "", FormatPNG:
+		return FormatPNG, nil
+	case FormatSVG:
+		return FormatSVG, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownFormat, s)
+}
+
+func (f Format) ContentType() string {
+	if f == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Level — уровень коррекции ошибок: L, M, Q или H.
+type Level string
+
+var levels = map[Level]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// ParseLevel разбирает уровень коррекции; пустая строка означает M.
+func ParseLevel(s string) (Level, error) {
+	if s == "" {
+		return "M", nil
+	}
+	level := Level(strings.ToUpper(s))
+	if _, ok := levels[level]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownLevel, s)
+	}
+	return level, nil
+}
+
+// Options — параметры изображения. Size — ширина и высота в пикселях,
+// QuietZone — ширина белой рамки в модулях (стандарт требует не меньше 4).
+type Options struct {
+	Format    Format
+	Level     Level
+	Size      int
+	QuietZone int
+}
+
+// Render кодирует content и пишет изображение в w.
+func Render(w io.Writer, content string, opts Options) error {
+	if opts.Size <= 0 || opts.Size > MaxSize {
+		return fmt.Errorf("%w: size must be between 1 and %d", ErrInvalidSize, MaxSize)
+	}
+	if opts.QuietZone < 0 || opts.QuietZone > MaxQuietZone {
+		return fmt.Errorf("%w: quiet zone must be between 0 and %d", ErrInvalidSize, MaxQuietZone)
+	}
+	level, ok := levels[opts.Level]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownLevel, opts.Level)
+	}
+
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return err
+	}
+	// рамку рисуем сами, чтобы её ширина настраивалась
+	code.DisableBorder = true
+	modules := code.Bitmap()
+
+	total := len(modules) + 2*opts.QuietZone
+	scale := opts.Size / total
+	if scale < 1 {
+		return fmt.Errorf("%w: at least %dpx needed", ErrInvalidSize, total)
+	}
+	// остаток от деления распределяется по краям, чтобы код был по центру
+	offset := (opts.Size-scale*total)/2 + opts.QuietZone*scale
+
+	if opts.Format == FormatSVG {
+		return writeSVG(w, modules, opts.Size, scale, offset)
+	}
+	return writePNG(w, modules, opts.Size, scale, offset)
+}
+
+func writePNG(w io.Writer, modules [][]bool, size, scale, offset int) error {
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+func writeSVG(w io.Writer, modules [][]bool, size, scale, offset int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, size, size)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y, row := range modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			// соседние тёмные модули строки объединяются в один прямоугольник
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			bw.WriteString("M" + strconv.Itoa(offset+x*scale) + " " + strconv.Itoa(offset+y*scale))
+			bw.WriteString("h" + strconv.Itoa(run*scale) + "v" + strconv.Itoa(scale) + "h-" + strconv.Itoa(run*scale) + "z")
+			x += run - 1
+		}
+	}
+	bw.WriteString(`"/></svg>`)
+	return bw.Flush()
+}
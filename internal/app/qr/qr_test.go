@@ -0,0 +1,61 @@
+package qr
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderPNG(t *testing.T) {
+	var buf bytes.Buffer
+	err := Render(&buf, "http://localhost:8080/abcdefghi", Options{Format: FormatPNG, Level: "L", Size: 200, QuietZone: 4})
+	assert.NoError(t, err)
+
+	img, err := png.Decode(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, img.Bounds().Dx())
+	assert.Equal(t, 200, img.Bounds().Dy())
+
+	// уровень L даёт версию 2: 25 модулей + рамка 2*4 = 33 модуля по 6px,
+	// код начинается с 1+24px
+	isBlack := func(x, y int) bool {
+		return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 0x80
+	}
+	assert.False(t, isBlack(0, 0), "quiet zone must be white")
+	assert.False(t, isBlack(24, 24))
+	assert.True(t, isBlack(25, 25), "finder pattern corner must be dark")
+	assert.True(t, isBlack(174, 25), "top-right finder pattern")
+	assert.True(t, isBlack(25, 174), "bottom-left finder pattern")
+}
+
+func TestRenderSVG(t *testing.T) {
+	var buf bytes.Buffer
+	err := Render(&buf, "http://localhost:8080/abcdefghi", Options{Format: FormatSVG, Level: "H", Size: 300, QuietZone: 0})
+	assert.NoError(t, err)
+
+	svg := buf.String()
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="300" height="300"`))
+	assert.Contains(t, svg, `<path fill="#000" d="M`)
+	assert.True(t, strings.HasSuffix(svg, `"/></svg>`))
+}
+
+func TestRenderErrors(t *testing.T) {
+	opts := Options{Format: FormatPNG, Level: "M", Size: 20, QuietZone: 4}
+	assert.ErrorIs(t, Render(&bytes.Buffer{}, "http://localhost:8080/abc", opts), ErrInvalidSize)
+
+	opts.Size, opts.QuietZone = 256, MaxQuietZone+1
+	assert.ErrorIs(t, Render(&bytes.Buffer{}, "http://localhost:8080/abc", opts), ErrInvalidSize)
+
+	opts.QuietZone, opts.Level = 4, "X"
+	assert.ErrorIs(t, Render(&bytes.Buffer{}, "http://localhost:8080/abc", opts), ErrUnknownLevel)
+
+	_, err := ParseFormat("gif")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+	level, err := ParseLevel("q")
+	assert.NoError(t, err)
+	assert.Equal(t, Level("Q"), level)
+}
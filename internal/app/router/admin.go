@@ -0,0 +1,135 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/export"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	adminDefaultLimit = 100
+	adminMaxLimit     = 1000
+)
+
+type adminURLResponse struct {
+	ID          string    `json:"id"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	UserID      string    `json:"user_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Clicks      int64     `json:"clicks"`
+	Disabled    bool      `json:"disabled"`
+}
+
+// parseAdminFilter разбирает условия поиска: q — подстрока исходного URL,
+// user_id — владелец, from и to — интервал дат создания, after и limit —
+// постраничный обход.
+func parseAdminFilter(r *http.Request) (storage.ListFilter, error) {
+	query := r.URL.Query()
+
+	from, err := export.ParseDate(query.Get("from"))
+	if err != nil {
+		return storage.ListFilter{}, err
+	}
+	to, err := export.ParseDate(query.Get("to"))
+	if err != nil {
+		return storage.ListFilter{}, err
+	}
+
+	limit := adminDefaultLimit
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > adminMaxLimit {
+			return storage.ListFilter{}, errors.New("limit must be between 1 and 1000")
+		}
+	}
+
+	return storage.ListFilter{
+		UserID:           query.Get("user_id"),
+		OriginalContains: query.Get("q"),
+		From:             from,
+		To:               to,
+		After:            query.Get("after"),
+		Limit:            limit,
+	}, nil
+}
+
+func adminSearchHandler(
+	search func(context.Context, storage.ListFilter) ([]storage.URLData, error),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAdminFilter(r)
+		if err != nil {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, err.Error()))
+			return
+		}
+
+		page, err := search(r.Context(), filter)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not search URLs"))
+			return
+		}
+
+		resp := make([]adminURLResponse, 0, len(page))
+		for _, data := range page {
+			shortURL, err := utils.ConstructURL(config.Config.BaseURL, data.ShortURL)
+			if err != nil {
+				problem.Write(w, r, problem.Internal(err, "could not construct URL"))
+				return
+			}
+			resp = append(resp, adminURLResponse{
+				ID:          data.ShortURL,
+				ShortURL:    shortURL,
+				OriginalURL: data.OriginalURL,
+				UserID:      data.UserID,
+				CreatedAt:   data.CreatedAt,
+				Clicks:      data.Clicks,
+				Disabled:    data.Disabled,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not encode response"))
+		}
+	}
+}
+
+func adminSetDisabledHandler(
+	setDisabled func(context.Context, string, bool) error,
+	disabled bool,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeAdminResult(w, r, setDisabled(r.Context(), chi.URLParam(r, "id"), disabled))
+	}
+}
+
+func adminDeleteHandler(
+	deleteURL func(context.Context, string) error,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeAdminResult(w, r, deleteURL(r.Context(), chi.URLParam(r, "id")))
+	}
+}
+
+func writeAdminResult(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, storage.ErrNotFound):
+		problem.Write(w, r, problem.New(problem.ErrNotFound, "url not found"))
+	default:
+		problem.Write(w, r, problem.Internal(err, "could not moderate URL"))
+	}
+}
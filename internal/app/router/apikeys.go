@@ -0,0 +1,119 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/auth"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type createAPIKeyPayload struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// apiKeyResponse описывает ключ без его хеша; Key заполняется
+// только в ответе на создание.
+type apiKeyResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Prefix    string    `json:"prefix"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	Key       string    `json:"key,omitempty"`
+}
+
+func newAPIKeyResponse(key storage.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+	}
+}
+
+func createAPIKeyHandler(
+	createAPIKey func(context.Context, string, []string) (storage.APIKey, string, error),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createAPIKeyPayload
+		if err := decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+
+		if len(req.Scopes) == 0 {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, "scopes are required"))
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !auth.ValidScope(scope) {
+				problem.Write(w, r, problem.New(problem.ErrBadRequest, "unknown scope "+scope))
+				return
+			}
+		}
+
+		key, token, err := createAPIKey(r.Context(), req.Name, req.Scopes)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not create api key"))
+			return
+		}
+
+		resp := newAPIKeyResponse(key)
+		resp.Key = token
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not encode response"))
+		}
+	}
+}
+
+func listAPIKeysHandler(
+	listAPIKeys func(context.Context) ([]storage.APIKey, error),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := listAPIKeys(r.Context())
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not list api keys"))
+			return
+		}
+
+		resp := make([]apiKeyResponse, 0, len(keys))
+		for _, key := range keys {
+			resp = append(resp, newAPIKeyResponse(key))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not encode response"))
+		}
+	}
+}
+
+func deleteAPIKeyHandler(
+	deleteAPIKey func(context.Context, string) error,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := deleteAPIKey(r.Context(), chi.URLParam(r, "id"))
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			problem.Write(w, r, err)
+			return
+		}
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not delete api key"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,88 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/condratf/shortner/internal/app/problem"
+)
+
+// errTrailingData — после JSON-значения в теле запроса есть что-то ещё.
+var errTrailingData = errors.New("request body must contain a single JSON value")
+
+// decodeJSON читает из тела запроса ровно одно JSON-значение в dst.
+// Неизвестные поля и данные после значения считаются ошибкой.
+func decodeJSON(r *http.Request, dst interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+
+	var extra json.RawMessage
+	switch err := dec.Decode(&extra); {
+	case errors.Is(err, io.EOF):
+		return nil
+	case bodyTooLarge(err) != nil:
+		return err
+	default:
+		return errTrailingData
+	}
+}
+
+func bodyTooLarge(err error) *http.MaxBytesError {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return tooLarge
+	}
+	return nil
+}
+
+// writeDecodeError отвечает 413 на превышение предела тела и 400
+// на любую другую ошибку разбора.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case bodyTooLarge(err) != nil:
+		problem.Write(w, r, err)
+	case errors.Is(err, io.EOF):
+		problem.Write(w, r, problem.New(problem.ErrBadRequest, "request body is empty"))
+	default:
+		problem.Write(w, r, problem.New(problem.ErrBadRequest, "could not decode request body: "+err.Error()))
+	}
+}
+
+// limitedReader возвращает *http.MaxBytesError, как только из r прочитано
+// больше limit байт. В отличие от http.MaxBytesReader, ограничивает
+// распакованные данные, а не то, что пришло по сети.
+type limitedReader struct {
+	r         io.Reader
+	limit     int64
+	remaining int64
+	err       error
+}
+
+func newLimitedReader(r io.Reader, limit int64) *limitedReader {
+	return &limitedReader{r: r, limit: limit, remaining: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	// лишний байт сверх остатка показывает, что предел превышен
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) <= l.remaining {
+		l.remaining -= int64(n)
+		l.err = err
+		return n, err
+	}
+	n = int(l.remaining)
+	l.remaining = 0
+	l.err = &http.MaxBytesError{Limit: l.limit}
+	return n, l.err
+}
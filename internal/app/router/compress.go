@@ -0,0 +1,271 @@
+package router
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// minCompressSize — ответы короче не сжимаются: заголовки и служебные
+// байты формата съели бы выигрыш.
+const minCompressSize = 1024
+
+// compressor — общий интерфейс писателей gzip, deflate, brotli и zstd.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressors хранит пулы писателей по имени кодировки: писатели
+// выделяют большие буферы, и создавать их на каждый ответ дорого.
+var compressors = map[string]*sync.Pool{
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+	}},
+	"zstd": {New: func() interface{} {
+		// ответ пишется одним потоком, лишние горутины кодировщика не нужны
+		w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+	"gzip": {New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}},
+	"deflate": {New: func() interface{} {
+		w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return w
+	}},
+}
+
+// encodingPreference задаёт выбор сервера между кодировками с равным q.
+var encodingPreference = []string{"br", "zstd", "gzip", "deflate"}
+
+// compressibleTypes — типы содержимого, которые имеет смысл сжимать,
+// помимо text/*.
+var compressibleTypes = map[string]bool{
+	"application/json":         true,
+	"application/problem+json": true,
+	"application/x-ndjson":     true,
+	"application/javascript":   true,
+	"application/xml":          true,
+	"image/svg+xml":            true,
+}
+
+// negotiateEncoding выбирает кодировку по Accept-Encoding с учётом q-значений.
+// Пустая строка означает, что ответ отправляется без сжатия.
+func negotiateEncoding(header string) string {
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range encodingPreference {
+		q, ok := weights[name]
+		if !ok {
+			// * относится только к кодировкам, не перечисленным явно
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// compressResponseWriter решает, сжимать ли ответ, при первом Write или
+// WriteHeader, когда обработчик уже выставил заголовки. Тело копится
+// в буфере, пока не станет ясно, что оно длиннее minCompressSize.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status      int
+	wroteHeader bool
+	// decided — сжимать или нет уже выбрано; при compress == nil
+	// ответ передаётся как есть
+	decided  bool
+	compress compressor
+	buf      []byte
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	h := cw.Header()
+	switch {
+	case status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified:
+		// у таких ответов нет тела
+		cw.passthrough()
+	case h.Get("Content-Encoding") != "":
+		cw.passthrough()
+	case h.Get("Content-Type") != "" && !compressible(h.Get("Content-Type")):
+		cw.passthrough()
+	case h.Get("Content-Length") != "":
+		if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressSize {
+			cw.passthrough()
+		}
+	}
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.compress != nil {
+			return cw.compress.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= minCompressSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide выбирает сжатие для накопленного тела и отправляет его.
+func (cw *compressResponseWriter) decide() error {
+	h := cw.Header()
+	if h.Get("Content-Type") == "" {
+		// как net/http, но до выбора: тип нужен для решения о сжатии
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if len(cw.buf) < minCompressSize || !compressible(h.Get("Content-Type")) {
+		cw.passthrough()
+		return cw.flushBuffer()
+	}
+
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	cw.compress = compressors[cw.encoding].Get().(compressor)
+	cw.compress.Reset(cw.ResponseWriter)
+	cw.decided = true
+	return cw.flushBuffer()
+}
+
+// passthrough отказывается от сжатия и отправляет заголовки как есть.
+func (cw *compressResponseWriter) passthrough() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressResponseWriter) flushBuffer() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.compress != nil {
+		_, err = cw.compress.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush отправляет накопленное клиенту; недописанный короткий ответ
+// при этом уходит без сжатия.
+func (cw *compressResponseWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide()
+	}
+	if cw.compress != nil {
+		cw.compress.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close завершает ответ и возвращает писатель в пул.
+func (cw *compressResponseWriter) close() error {
+	if !cw.wroteHeader {
+		// обработчик ничего не написал: net/http сам отправит 200
+		return nil
+	}
+	if !cw.decided {
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+	if cw.compress == nil {
+		return nil
+	}
+	err := cw.compress.Close()
+	cw.compress.Reset(io.Discard)
+	compressors[cw.encoding].Put(cw.compress)
+	cw.compress = nil
+	return err
+}
+
+// compressionMiddleware сжимает ответы кодировкой, выбранной
+// по Accept-Encoding клиента.
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
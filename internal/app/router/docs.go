@@ -0,0 +1,55 @@
+package router
+
+import (
+	"embed"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//go:generate sh vendor-swagger-ui.sh
+
+// docsFS — описание API в формате OpenAPI 3 и страница Swagger UI, которая
+// его показывает. При изменении маршрутов или тел запросов docs/openapi.json
+// правится вместе с обработчиками; TestOpenAPIConformance проверяет, что они
+// не разошлись. Сборка Swagger UI лежит в docs/swagger-ui, см. go:generate.
+//
+//go:embed docs
+var docsFS embed.FS
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	serveDoc(w, r, "openapi.json")
+}
+
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	serveDoc(w, r, "index.html")
+}
+
+// docsAssetHandler отдаёт файлы страницы документации, в том числе Swagger UI.
+func docsAssetHandler(w http.ResponseWriter, r *http.Request) {
+	serveDoc(w, r, chi.URLParam(r, "*"))
+}
+
+func serveDoc(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := docsFS.Open(path.Join("docs", name))
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		notFound(w, r)
+		return
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+)
+
+const (
+	responseTypeJSON      = "json"
+	responseTypeJSONBatch = "json-batch"
+	responseTypeText      = "text"
+)
+
+// writeURLExists отвечает 409 на попытку сократить уже сохранённый URL.
+// Существующая короткая ссылка возвращается в тех же полях, что и при
+// успешном создании: result, correlation_id и short_url, или текстом.
+func writeURLExists(w http.ResponseWriter, r *http.Request, err error, respType string) bool {
+	var exists *storage.ErrURLExists
+	if !errors.As(err, &exists) {
+		return false
+	}
+
+	shortURL, err := utils.ConstructURL(config.Config.BaseURL, exists.ExistingShortURL)
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err, "could not construct URL"))
+		return true
+	}
+	shortURL = strings.TrimSpace(shortURL)
+
+	if respType == responseTypeText {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(shortURL))
+		return true
+	}
+
+	p := problem.FromError(exists)
+	switch respType {
+	case responseTypeJSON:
+		p.Extensions = map[string]interface{}{"result": shortURL}
+	case responseTypeJSONBatch:
+		p.Extensions = map[string]interface{}{
+			"correlation_id": exists.ID,
+			"short_url":      shortURL,
+		}
+	}
+	problem.Respond(w, r, p)
+	return true
+}
+
+// plainTextErrors переводит ошибки POST / в text/plain: этот эндпоинт
+// принимает и возвращает простой текст. Проверка идёт до маршрутизации,
+// чтобы так же отвечали и общие middleware.
+func plainTextErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/" {
+			r = r.WithContext(problem.WithPlainText(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,40 +3,53 @@ package router
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
 
-	"github.com/condratf/shortner/internal/app/errorhandler"
+	"github.com/condratf/shortner/internal/app/auth"
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/export"
 	"github.com/condratf/shortner/internal/app/models"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
 	"github.com/go-chi/chi/v5"
 )
 
 type requestPayload struct {
 	URL string `json:"url"`
+	models.LinkOptions
 }
 
 type responsePayload struct {
 	Result string `json:"result"`
 }
 
-func createShortURLHandlerAPIShorten(shortURLAndStore func(string) (string, error)) func(w http.ResponseWriter, r *http.Request) {
+func createShortURLHandlerAPIShorten(shortURLAndStore func(context.Context, string, models.LinkOptions) (string, error)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req requestPayload
-		err := json.NewDecoder(r.Body).Decode(&req)
-		if err != nil || len(req.URL) < 1 {
-			http.Error(w, "could not decode request body", http.StatusBadRequest)
+		if err := decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+		if len(req.URL) < 1 {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, "url is required"))
+			return
+		}
+		if req.MaxClicks < 0 {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, "max_clicks must not be negative"))
 			return
 		}
 
-		defer r.Body.Close()
-
-		shortURL, err := shortURLAndStore(req.URL)
+		shortURL, err := shortURLAndStore(r.Context(), req.URL, req.LinkOptions)
 		if err != nil {
-			if errorhandler.HandleURLExistError(w, err, "json") {
+			if writeURLExists(w, r, err, responseTypeJSON) {
 				return
 			}
-			http.Error(w, "could not store URL", http.StatusInternalServerError)
+			writeStoreError(w, r, err)
 			return
 		}
 
@@ -44,28 +57,31 @@ func createShortURLHandlerAPIShorten(shortURLAndStore func(string) (string, erro
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			http.Error(w, "could not encode response", http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err, "could not encode response"))
 		}
 	}
 }
 
 func createShortURLHandlerAPIShortenBatch(
-	shortURLAndStoreBatch func([]models.RequestPayloadBatch) ([]models.BatchItem, error),
+	shortURLAndStoreBatch func(context.Context, []models.RequestPayloadBatch) ([]models.BatchItem, error),
 ) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req []models.RequestPayloadBatch
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req) == 0 {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if err := decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+		if len(req) == 0 {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, "batch must not be empty"))
 			return
 		}
-		defer r.Body.Close()
 
-		batchData, err := shortURLAndStoreBatch(req)
+		batchData, err := shortURLAndStoreBatch(r.Context(), req)
 		if err != nil {
-			if errorhandler.HandleURLExistError(w, err, "json-batch") {
+			if writeURLExists(w, r, err, responseTypeJSONBatch) {
 				return
 			}
-			http.Error(w, "Failed to process batch", http.StatusInternalServerError)
+			writeStoreError(w, r, err)
 			return
 		}
 
@@ -80,27 +96,29 @@ func createShortURLHandlerAPIShortenBatch(
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err, "could not encode response"))
 		}
 	}
 }
 
-func createShortURLHandler(shortURLAndStore func(string) (string, error)) func(w http.ResponseWriter, r *http.Request) {
+func createShortURLHandler(shortURLAndStore func(context.Context, string, models.LinkOptions) (string, error)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		url, err := io.ReadAll(r.Body)
-		defer r.Body.Close()
-
+		if bodyTooLarge(err) != nil {
+			problem.Write(w, r, err)
+			return
+		}
 		if err != nil || len(url) == 0 {
-			http.Error(w, "could not read request body", http.StatusBadRequest)
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, "could not read request body"))
 			return
 		}
 
-		shortURL, err := shortURLAndStore(string(url))
+		shortURL, err := shortURLAndStore(r.Context(), string(url), models.LinkOptions{})
 		if err != nil {
-			if errorhandler.HandleURLExistError(w, err, "text") {
+			if writeURLExists(w, r, err, responseTypeText) {
 				return
 			}
-			http.Error(w, "could not store URL", http.StatusInternalServerError)
+			writeStoreError(w, r, err)
 			return
 		}
 
@@ -110,24 +128,258 @@ func createShortURLHandler(shortURLAndStore func(string) (string, error)) func(w
 	}
 }
 
-func redirectHandler(getURL func(string) (string, error)) func(w http.ResponseWriter, r *http.Request) {
+func redirectHandler(
+	getURL func(context.Context, string) (storage.URLData, error),
+	clickURL func(context.Context, string) (storage.URLData, error),
+	notFound http.Handler,
+) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 
 		if id == "" {
-			w.WriteHeader(http.StatusBadRequest)
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, "short URL key is required"))
 			return
 		}
 
-		url, err := getURL(id)
+		data, err := getURL(r.Context(), id)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeLookupError(w, r, err, notFound)
+			return
+		}
+		if data.Disabled {
+			problem.Write(w, r, problem.ErrLinkDisabled)
+			return
+		}
+		if data.Expired(time.Now()) {
+			problem.Write(w, r, problem.ErrLinkExpired)
+			return
+		}
+		if data.PasswordHash != "" {
+			renderUnlockPage(w, http.StatusOK, unlockPage{})
 			return
 		}
 
+		// боты превью не расходуют лимит переходов, поэтому адрес назначения
+		// ограниченной ссылки им не выдаётся: только страница с og:*-тегами
+		if isCrawler(r.UserAgent()) {
+			if data.MaxClicks > 0 && data.Clicks >= int64(data.MaxClicks) {
+				problem.Write(w, r, storage.ErrClickLimitReached)
+				return
+			}
+			if data.PageMeta != nil || data.Restricted() {
+				renderUnfurlPage(w, r, data)
+				return
+			}
+		} else {
+			data, err = clickURL(r.Context(), id)
+			if err != nil {
+				if errors.Is(err, storage.ErrClickLimitReached) {
+					problem.Write(w, r, err)
+					return
+				}
+				writeLookupError(w, r, err, notFound)
+				return
+			}
+		}
+
+		status := data.RedirectType
+		if status == 0 {
+			status = http.StatusTemporaryRedirect
+		}
+
 		w.Header().Set("Content-Type", "text/plain")
-		w.Header().Set("Location", url)
-		w.WriteHeader(http.StatusTemporaryRedirect)
+		w.Header().Set("Location", data.OriginalURL)
+		w.WriteHeader(status)
+	}
+}
+
+// redirectTypes — коды, которые можно задать ссылке через PATCH /api/urls/{id}
+var redirectTypes = map[int]struct{}{
+	http.StatusMovedPermanently:  {},
+	http.StatusFound:             {},
+	http.StatusSeeOther:          {},
+	http.StatusTemporaryRedirect: {},
+	http.StatusPermanentRedirect: {},
+}
+
+// updatePayload — тело PATCH-запроса; отсутствующие поля не меняются,
+// а "expires_at": null снимает срок действия.
+type updatePayload struct {
+	OriginalURL  *string         `json:"original_url"`
+	RedirectType *int            `json:"redirect_type"`
+	ExpiresAt    json.RawMessage `json:"expires_at"`
+}
+
+func (p updatePayload) toUpdate() (storage.URLUpdate, error) {
+	upd := storage.URLUpdate{
+		OriginalURL:  p.OriginalURL,
+		RedirectType: p.RedirectType,
+	}
+
+	if p.RedirectType != nil {
+		if _, ok := redirectTypes[*p.RedirectType]; !ok {
+			return upd, fmt.Errorf("unsupported redirect_type %d", *p.RedirectType)
+		}
+	}
+
+	switch {
+	case p.ExpiresAt == nil:
+	case string(p.ExpiresAt) == "null":
+		upd.ClearExpiry = true
+	default:
+		var expiresAt time.Time
+		if err := json.Unmarshal(p.ExpiresAt, &expiresAt); err != nil {
+			return upd, fmt.Errorf("invalid expires_at: %w", err)
+		}
+		upd.ExpiresAt = &expiresAt
+	}
+
+	if upd.OriginalURL == nil && upd.RedirectType == nil && upd.ExpiresAt == nil && !upd.ClearExpiry {
+		return upd, errors.New("nothing to update")
+	}
+
+	return upd, nil
+}
+
+type linkResponse struct {
+	ShortURL     string     `json:"short_url"`
+	OriginalURL  string     `json:"original_url"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+func updateURLHandler(
+	updateURL func(context.Context, string, storage.URLUpdate) (storage.URLData, error),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		var req updatePayload
+		if err := decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+
+		upd, err := req.toUpdate()
+		if err != nil {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, err.Error()))
+			return
+		}
+
+		data, err := updateURL(r.Context(), id, upd)
+		if err != nil {
+			if writeURLExists(w, r, err, responseTypeJSON) {
+				return
+			}
+			writeLinkError(w, r, err)
+			return
+		}
+
+		shortURL, err := utils.ConstructURL(config.Config.BaseURL, data.ShortURL)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not construct URL"))
+			return
+		}
+
+		resp := linkResponse{
+			ShortURL:     shortURL,
+			OriginalURL:  data.OriginalURL,
+			RedirectType: data.RedirectType,
+			ExpiresAt:    data.ExpiresAt,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not encode response"))
+		}
+	}
+}
+
+func urlHistoryHandler(
+	urlHistory func(context.Context, string) ([]storage.HistoryEntry, error),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := urlHistory(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			writeLinkError(w, r, err)
+			return
+		}
+		if entries == nil {
+			entries = []storage.HistoryEntry{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not encode response"))
+		}
+	}
+}
+
+type brokenURLResponse struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	Status      int       `json:"status,omitempty"`
+	LatencyMS   int64     `json:"latency_ms"`
+	Error       string    `json:"error,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+func brokenURLsHandler(
+	brokenURLs func(context.Context, string) ([]storage.URLData, error),
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		urls, err := brokenURLs(r.Context(), auth.UserIDFromContext(r.Context()))
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not list URLs"))
+			return
+		}
+
+		resp := make([]brokenURLResponse, 0, len(urls))
+		for _, data := range urls {
+			shortURL, err := utils.ConstructURL(config.Config.BaseURL, data.ShortURL)
+			if err != nil {
+				problem.Write(w, r, problem.Internal(err, "could not construct URL"))
+				return
+			}
+			resp = append(resp, brokenURLResponse{
+				ShortURL:    shortURL,
+				OriginalURL: data.OriginalURL,
+				Status:      data.Health.Status,
+				LatencyMS:   data.Health.LatencyMS,
+				Error:       data.Health.Error,
+				CheckedAt:   data.Health.CheckedAt,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not encode response"))
+		}
+	}
+}
+
+// writeStoreError отвечает на ошибку сохранения новой ссылки.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, utils.ErrInvalidURL) {
+		problem.Write(w, r, err)
+		return
+	}
+	problem.Write(w, r, problem.Internal(err, "could not store URL"))
+}
+
+// writeLinkError отвечает на ошибку операции с существующей ссылкой.
+func writeLinkError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidURL):
+		problem.Write(w, r, err)
+	case errors.Is(err, storage.ErrNotFound):
+		problem.Write(w, r, problem.New(problem.ErrNotFound, "url not found"))
+	case errors.Is(err, storage.ErrForbidden):
+		problem.Write(w, r, problem.New(problem.ErrForbidden, "url belongs to another user"))
+	default:
+		problem.Write(w, r, problem.Internal(err, "could not update URL"))
 	}
 }
 
@@ -137,10 +389,50 @@ func createPingHandler(pingDB func(ctx context.Context) error) func(w http.Respo
 		defer cancel()
 
 		if err := pingDB(ctx); err != nil {
-			http.Error(w, "Database connection error", http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err, "database connection error"))
 			return
 		}
+		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	}
 }
+
+func exportHandler(
+	exportURLs func(context.Context, io.Writer, export.Format, storage.ListFilter) error,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		format, err := export.ParseFormat(query.Get("format"))
+		if err != nil {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, err.Error()))
+			return
+		}
+
+		from, err := export.ParseDate(query.Get("from"))
+		if err != nil {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, err.Error()))
+			return
+		}
+		to, err := export.ParseDate(query.Get("to"))
+		if err != nil {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, err.Error()))
+			return
+		}
+
+		filter := storage.ListFilter{
+			UserID: auth.UserIDFromContext(r.Context()),
+			From:   from,
+			To:     to,
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="urls.%s"`, format))
+		w.WriteHeader(http.StatusOK)
+		if err := exportURLs(r.Context(), w, format, filter); err != nil {
+			// заголовки уже отправлены, поэтому остаётся только оборвать ответ
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
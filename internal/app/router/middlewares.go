@@ -5,67 +5,84 @@ import (
 	"compress/gzip"
 	"io"
 	"net/http"
-	"strings"
-)
 
-type compressedResponseWriter struct {
-	http.ResponseWriter
-	writer io.Writer
-}
+	"github.com/condratf/shortner/internal/app/auth"
+	"github.com/condratf/shortner/internal/app/logger"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
 
-func (w *compressedResponseWriter) Write(b []byte) (int, error) {
-	return w.writer.Write(b)
-}
+// decompressMiddleware распаковывает тело запроса по Content-Encoding.
+// maxBody ограничивает тело в том виде, в каком оно пришло, maxDecompressed —
+// после распаковки, чтобы gzip-бомба не исчерпала память; 0 отключает предел.
+func decompressMiddleware(maxBody, maxDecompressed int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxBody > 0 {
+				if r.ContentLength > maxBody {
+					problem.Write(w, r, &http.MaxBytesError{Limit: maxBody})
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+			}
 
-func compressionMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		encoding := r.Header.Get("Accept-Encoding")
-		contentType := w.Header().Get("Content-Type")
+			var body io.Reader
+			switch r.Header.Get("Content-Encoding") {
+			case "gzip":
+				gzReader, err := gzip.NewReader(r.Body)
+				if err != nil {
+					if bodyTooLarge(err) != nil {
+						problem.Write(w, r, err)
+						return
+					}
+					problem.Write(w, r, problem.New(problem.ErrBadRequest, "could not decompress gzip body"))
+					return
+				}
+				defer gzReader.Close()
+				body = gzReader
 
-		if strings.Contains(encoding, "gzip") && (contentType == "application/json" || contentType == "text/html") {
-			w.Header().Set("Content-Encoding", "gzip")
-			gzWriter := gzip.NewWriter(w)
-			defer gzWriter.Close()
-			w = &compressedResponseWriter{ResponseWriter: w, writer: gzWriter}
-		}
+			case "deflate":
+				flReader := flate.NewReader(r.Body)
+				defer flReader.Close()
+				body = flReader
 
-		if strings.Contains(encoding, "deflate") && (contentType == "application/json" || contentType == "text/html") {
-			w.Header().Set("Content-Encoding", "deflate")
-			flWriter, _ := flate.NewWriter(w, flate.DefaultCompression)
-			defer flWriter.Close()
-			w = &compressedResponseWriter{ResponseWriter: w, writer: flWriter}
-		}
+			case "":
 
-		next.ServeHTTP(w, r)
-	})
-}
-
-func decompressMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		contentEncoding := r.Header.Get("Content-Encoding")
-
-		switch contentEncoding {
-		case "gzip":
-			gzReader, err := gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "could not decompress gzip body", http.StatusBadRequest)
+			default:
+				problem.Write(w, r, problem.New(problem.ErrUnsupportedMediaType, "unsupported content encoding"))
 				return
 			}
-			defer gzReader.Close()
-			r.Body = io.NopCloser(gzReader)
 
-		case "deflate":
-			flReader := flate.NewReader(r.Body)
-			defer flReader.Close()
-			r.Body = io.NopCloser(flReader)
+			if body != nil {
+				if maxDecompressed > 0 {
+					body = newLimitedReader(body, maxDecompressed)
+				}
+				r.Body = io.NopCloser(body)
+				r.Header.Del("Content-Encoding")
+				r.ContentLength = -1
+			}
 
-		case "":
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		default:
-			http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
-			return
+// logRequestFields добавляет в записи лога адрес клиента и пользователя запроса.
+func logRequestFields(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := []zap.Field{zap.String("remote_ip", clientIP(r))}
+		if userID := auth.UserIDFromContext(r.Context()); userID != "" {
+			fields = append(fields, zap.String("user_id", userID))
 		}
+		next.ServeHTTP(w, r.WithContext(logger.With(r.Context(), fields...)))
+	})
+}
 
-		next.ServeHTTP(w, r)
+// logShortKey добавляет в записи лога ключ ссылки из маршрута.
+func logShortKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := logger.With(r.Context(), zap.String("short_key", chi.URLParam(r, "id")))
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -0,0 +1,46 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
+)
+
+// NotFoundHandler отвечает на переход по неизвестному ключу: перенаправляет
+// на redirectURL, если он задан, иначе отдаёт page с кодом 404. Без обоих
+// ответ — 404 в формате problem.
+func NotFoundHandler(redirectURL string, page []byte) http.Handler {
+	switch {
+	case redirectURL != "":
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, redirectURL, http.StatusFound)
+		})
+	case page != nil:
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(page)
+		})
+	default:
+		return http.HandlerFunc(unknownKey)
+	}
+}
+
+func unknownKey(w http.ResponseWriter, r *http.Request) {
+	problem.Write(w, r, problem.New(problem.ErrNotFound, "url not found"))
+}
+
+// writeLookupError отвечает на ошибку поиска ссылки по ключу: неизвестный
+// ключ обрабатывает notFound, а всё остальное считается сбоем хранилища.
+func writeLookupError(w http.ResponseWriter, r *http.Request, err error, notFound http.Handler) {
+	if errors.Is(err, storage.ErrNotFound) {
+		notFound.ServeHTTP(w, r)
+		return
+	}
+	problem.Write(w, r, problem.Internal(err, "could not get URL"))
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type previewPage struct {
+	ShortURL string
+	// OriginalURL пуст, если адрес скрыт
+	OriginalURL string
+	Protected   bool
+	CreatedAt   time.Time
+	Clicks      int64
+	MaxClicks   int
+	Notice      string
+}
+
+// previewHandler показывает, куда ведёт ссылка, не выполняя переход и не
+// учитывая его. Кнопка продолжения ведёт на саму короткую ссылку, чтобы
+// пароль и лимит переходов продолжали действовать.
+func previewHandler(getURL func(context.Context, string) (storage.URLData, error), notFound http.Handler) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		data, err := getURL(r.Context(), id)
+		if err != nil {
+			writeLookupError(w, r, err, notFound)
+			return
+		}
+		if data.Disabled {
+			problem.Write(w, r, problem.ErrLinkDisabled)
+			return
+		}
+
+		shortURL, err := utils.ConstructURL(config.Config.BaseURL, data.ShortURL)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not construct URL"))
+			return
+		}
+
+		page := previewPage{
+			ShortURL:  shortURL,
+			Protected: data.PasswordHash != "",
+			CreatedAt: data.CreatedAt,
+			Clicks:    data.Clicks,
+			MaxClicks: data.MaxClicks,
+		}
+		// адрес ограниченной ссылки виден только после перехода,
+		// иначе превью обходило бы пароль, лимит и срок действия
+		if !data.Restricted() {
+			page.OriginalURL = data.OriginalURL
+		}
+		switch {
+		case data.Expired(time.Now()):
+			page.Notice = "This link has expired."
+		case data.MaxClicks > 0 && data.Clicks >= int64(data.MaxClicks):
+			page.Notice = "This link has reached its click limit."
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		pageTemplates.ExecuteTemplate(w, "preview.html", page)
+	}
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/qr"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// qrMaxAge — короткий адрес ключа не меняется, поэтому картинку можно кешировать надолго
+const qrMaxAge = 24 * 60 * 60
+
+func parseQROptions(r *http.Request) (qr.Options, error) {
+	query := r.URL.Query()
+	opts := qr.Options{Size: qr.DefaultSize, QuietZone: qr.DefaultQuietZone}
+
+	var err error
+	if opts.Format, err = qr.ParseFormat(query.Get("format")); err != nil {
+		return opts, err
+	}
+	if opts.Level, err = qr.ParseLevel(query.Get("ecc")); err != nil {
+		return opts, err
+	}
+	if s := query.Get("size"); s != "" {
+		if opts.Size, err = strconv.Atoi(s); err != nil {
+			return opts, fmt.Errorf("%w: %s", qr.ErrInvalidSize, s)
+		}
+	}
+	if s := query.Get("quiet_zone"); s != "" {
+		if opts.QuietZone, err = strconv.Atoi(s); err != nil {
+			return opts, fmt.Errorf("%w: %s", qr.ErrInvalidSize, s)
+		}
+	}
+	return opts, nil
+}
+
+func qrHandler(getURL func(context.Context, string) (storage.URLData, error)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		opts, err := parseQROptions(r)
+		if err != nil {
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, err.Error()))
+			return
+		}
+
+		data, err := getURL(r.Context(), id)
+		if err != nil {
+			// картинке не нужна страница 404 или перенаправление
+			writeLookupError(w, r, err, http.HandlerFunc(unknownKey))
+			return
+		}
+		// код для ссылки, по которой нельзя перейти, не выдаётся
+		if data.Disabled {
+			unknownKey(w, r)
+			return
+		}
+		if data.Expired(time.Now()) {
+			problem.Write(w, r, problem.ErrLinkExpired)
+			return
+		}
+
+		shortURL, err := utils.ConstructURL(config.Config.BaseURL, id)
+		if err != nil {
+			problem.Write(w, r, problem.Internal(err, "could not construct URL"))
+			return
+		}
+
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(fmt.Sprintf("%s|%+v", shortURL, opts))))
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", qrMaxAge))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := qr.Render(&buf, shortURL, opts); err != nil {
+			w.Header().Del("Cache-Control")
+			w.Header().Del("ETag")
+			problem.Write(w, r, problem.New(problem.ErrBadRequest, err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", opts.Format.ContentType())
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,187 @@
+package router
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/auth"
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/problem"
+)
+
+// rateLimitSweepInterval — как часто из таблицы удаляются простаивающие корзины
+const rateLimitSweepInterval = time.Minute
+
+// rateLimiter — ограничитель по алгоритму token bucket: у каждого клиента
+// своя корзина на burst токенов, пополняемая со скоростью rate в секунду.
+// Полностью восстановившиеся корзины ничем не отличаются от новых
+// и периодически удаляются.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     int
+	now       func() time.Time
+	lastSweep time.Time
+	buckets   map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateDecision — результат проверки запроса и значения заголовков RateLimit-*.
+type rateDecision struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (l *rateLimiter) allow(key string) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	d := rateDecision{allowed: b.tokens >= 1}
+	if d.allowed {
+		b.tokens--
+	} else {
+		d.retryAfter = l.refillTime(1 - b.tokens)
+	}
+	d.remaining = int(b.tokens)
+	d.reset = l.refillTime(float64(l.burst) - b.tokens)
+	return d
+}
+
+// refillTime возвращает время, за которое в корзину добавится tokens токенов.
+func (l *rateLimiter) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.refillTime(float64(l.burst)-b.tokens) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitMiddleware отвечает 429 клиентам, исчерпавшим лимит limiter.
+// Если limiter равен nil, ограничение отключено.
+func rateLimitMiddleware(limiter *rateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := limiter.allow(rateLimitKey(r))
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limiter.burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.reset)))
+			if !d.allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(d.retryAfter)))
+				problem.Write(w, r, problem.ErrRateLimited)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// newConfiguredRateLimiter создаёт ограничитель по настройкам; 0 отключает его.
+func newConfiguredRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return newRateLimiter(rate, burst)
+}
+
+// rateLimitKey определяет клиента: запросы с ключом доступа — по ключу,
+// пользователя JWT — по его идентификатору, остальных — по IP-адресу.
+// Подписанную куку сервис выдаёт на любой запрос без неё, так что по
+// куке клиент мог бы получать новую корзину сколько угодно раз.
+func rateLimitKey(r *http.Request) string {
+	id := auth.FromContext(r.Context())
+	if id.APIKeyID != "" {
+		return "key:" + id.APIKeyID
+	}
+	if id.Bearer {
+		return "user:" + id.UserID
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP возвращает адрес клиента. Если запрос пришёл от доверенного
+// прокси, адрес берётся из X-Forwarded-For: цепочка просматривается
+// справа налево до первого адреса, не принадлежащего доверенным прокси.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(addr) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// испорченный заголовок: дальше по цепочке верить нельзя
+			break
+		}
+		addr = hop.Unmap()
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range config.Config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
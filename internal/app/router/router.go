@@ -2,30 +2,105 @@ package router
 
 import (
 	"context"
+	"io"
 	"net/http"
 
+	"github.com/condratf/shortner/internal/app/auth"
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/export"
 	"github.com/condratf/shortner/internal/app/models"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
 	"github.com/go-chi/chi/v5"
 )
 
-func ShortenerRouter(
-	shortURLAndStore func(string) (string, error),
-	getURL func(string) (string, error),
-	shortURLAndStoreBatch func([]models.RequestPayloadBatch) ([]models.BatchItem, error),
-	pingDB func(ctx context.Context) error,
-) http.Handler {
+// Handlers — операции сервиса, которые роутер привязывает к маршрутам.
+type Handlers struct {
+	ShortURLAndStore func(ctx context.Context, originalURL string, opts models.LinkOptions) (string, error)
+	GetURL           func(ctx context.Context, key string) (storage.URLData, error)
+	UnlockURL        func(ctx context.Context, key, password string) (storage.URLData, error)
+	// ClickURL учитывает переход и возвращает storage.ErrClickLimitReached для исчерпанных ссылок
+	ClickURL              func(ctx context.Context, key string) (storage.URLData, error)
+	ShortURLAndStoreBatch func(ctx context.Context, items []models.RequestPayloadBatch) ([]models.BatchItem, error)
+	PingDB                func(ctx context.Context) error
+	ExportURLs            func(ctx context.Context, w io.Writer, format export.Format, filter storage.ListFilter) error
+	UpdateURL             func(ctx context.Context, key string, upd storage.URLUpdate) (storage.URLData, error)
+	URLHistory            func(ctx context.Context, key string) ([]storage.HistoryEntry, error)
+	// BrokenURLs возвращает ссылки пользователя с неудачной последней проверкой доступности
+	BrokenURLs func(ctx context.Context, userID string) ([]storage.URLData, error)
+	// CreateAPIKey выпускает ключ доступа и возвращает его вместе с самим ключом,
+	// который больше нигде не хранится
+	CreateAPIKey func(ctx context.Context, name string, scopes []string) (storage.APIKey, string, error)
+	ListAPIKeys  func(ctx context.Context) ([]storage.APIKey, error)
+	DeleteAPIKey func(ctx context.Context, id string) error
+	LookupAPIKey auth.TokenResolver
+	// VerifyJWT проверяет JWT в режиме аутентификации auth.ModeJWT
+	VerifyJWT auth.TokenResolver
+	// AdminSearch, AdminSetDisabled и AdminDelete — модерация ссылок
+	// с записью в журнал аудита
+	AdminSearch      func(ctx context.Context, filter storage.ListFilter) ([]storage.URLData, error)
+	AdminSetDisabled func(ctx context.Context, key string, disabled bool) error
+	AdminDelete      func(ctx context.Context, key string) error
+	// NotFound отвечает на переход по неизвестному ключу, см. NotFoundHandler;
+	// nil — ответ 404 в формате problem
+	NotFound http.Handler
+}
+
+func ShortenerRouter(h Handlers) http.Handler {
 	r := chi.NewRouter()
 	r.Use(compressionMiddleware)
-	r.Use(decompressMiddleware)
+	r.Use(plainTextErrors)
+	r.Use(decompressMiddleware(config.Config.MaxBodySize, config.Config.MaxDecompressedBodySize))
+	r.Use(auth.Middleware(auth.Options{
+		Mode:         config.Config.AuthMode,
+		LookupAPIKey: h.LookupAPIKey,
+		VerifyJWT:    h.VerifyJWT,
+	}))
+	r.Use(logRequestFields)
+
+	createLimit := rateLimitMiddleware(newConfiguredRateLimiter(config.Config.CreateRateLimit, config.Config.CreateRateBurst))
+	redirectLimit := rateLimitMiddleware(newConfiguredRateLimiter(config.Config.RedirectRateLimit, config.Config.RedirectRateBurst))
+
+	keyNotFound := h.NotFound
+	if keyNotFound == nil {
+		keyNotFound = NotFoundHandler("", nil)
+	}
 
-	r.Get("/ping", createPingHandler(pingDB))
-	r.Get("/{id}", redirectHandler(getURL))
-	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		http.NotFound(w, r)
+	r.Get("/ping", createPingHandler(h.PingDB))
+	r.Get("/api/openapi.json", openAPIHandler)
+	r.Get("/api/docs", docsHandler)
+	r.Get("/api/docs/*", docsAssetHandler)
+	r.With(logShortKey, redirectLimit).Get("/{id}", redirectHandler(h.GetURL, h.ClickURL, keyNotFound))
+	r.With(logShortKey, redirectLimit).Get("/{id}+", previewHandler(h.GetURL, keyNotFound))
+	r.With(logShortKey, redirectLimit).Get("/{id}/qr", qrHandler(h.GetURL))
+	r.With(logShortKey, redirectLimit).Post("/{id}", unlockHandler(h.UnlockURL, newUnlockLimiter(unlockMaxFailures, unlockWindow), keyNotFound))
+	r.Get("/", notFound)
+	r.With(createLimit, auth.RequireScope(auth.ScopeShorten)).Post("/", createShortURLHandler(h.ShortURLAndStore))
+	r.With(createLimit, auth.RequireScope(auth.ScopeShorten)).Post("/api/shorten", createShortURLHandlerAPIShorten(h.ShortURLAndStore))
+	r.With(createLimit, auth.RequireScope(auth.ScopeShorten)).Post("/api/shorten/batch", createShortURLHandlerAPIShortenBatch(h.ShortURLAndStoreBatch))
+	r.With(auth.RequireUser, auth.RequireScope(auth.ScopeRead)).Get("/api/user/urls/export", exportHandler(h.ExportURLs))
+	r.With(auth.RequireUser, auth.RequireScope(auth.ScopeRead)).Get("/api/user/urls/broken", brokenURLsHandler(h.BrokenURLs))
+	r.With(auth.RequireUser, auth.RequireScope(auth.ScopeShorten), logShortKey).Patch("/api/urls/{id}", updateURLHandler(h.UpdateURL))
+	r.With(auth.RequireUser, auth.RequireScope(auth.ScopeRead), logShortKey).Get("/api/urls/{id}/history", urlHistoryHandler(h.URLHistory))
+	r.With(auth.RequireSession).Post("/api/keys", createAPIKeyHandler(h.CreateAPIKey))
+	r.With(auth.RequireSession).Get("/api/keys", listAPIKeysHandler(h.ListAPIKeys))
+	r.With(auth.RequireSession).Delete("/api/keys/{id}", deleteAPIKeyHandler(h.DeleteAPIKey))
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(auth.RequireAdmin)
+		r.Get("/urls", adminSearchHandler(h.AdminSearch))
+		r.With(logShortKey).Post("/urls/{id}/disable", adminSetDisabledHandler(h.AdminSetDisabled, true))
+		r.With(logShortKey).Post("/urls/{id}/enable", adminSetDisabledHandler(h.AdminSetDisabled, false))
+		r.With(logShortKey).Delete("/urls/{id}", adminDeleteHandler(h.AdminDelete))
+	})
+
+	r.NotFound(notFound)
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, problem.ErrMethodNotAllowed)
 	})
-	r.Post("/", createShortURLHandler(shortURLAndStore))
-	r.Post("/api/shorten", createShortURLHandlerAPIShorten(shortURLAndStore))
-	r.Post("/api/shorten/batch", createShortURLHandlerAPIShortenBatch(shortURLAndStoreBatch))
 
 	return r
 }
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	problem.Write(w, r, problem.ErrNotFound)
+}
@@ -1,18 +1,43 @@
 package router
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"mime"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/condratf/shortner/internal/app/auth"
 	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/export"
+	"github.com/condratf/shortner/internal/app/logger"
 	"github.com/condratf/shortner/internal/app/models"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestShortenerRouter(t *testing.T) {
@@ -24,9 +49,10 @@ func TestShortenerRouter(t *testing.T) {
 		expectedStatus        int
 		expectedBody          string
 		expectedHeader        string
-		shortURLAndStore      func(string) (string, error)
-		shortURLAndStoreBatch func([]models.RequestPayloadBatch) ([]models.BatchItem, error)
-		getURL                func(string) (string, error)
+		shortURLAndStore      func(context.Context, string, models.LinkOptions) (string, error)
+		shortURLAndStoreBatch func(context.Context, []models.RequestPayloadBatch) ([]models.BatchItem, error)
+		getURL                func(context.Context, string) (storage.URLData, error)
+		clickURL              func(context.Context, string) (storage.URLData, error)
 	}{
 		{
 			name:           "GET request with valid ID",
@@ -34,20 +60,70 @@ func TestShortenerRouter(t *testing.T) {
 			path:           "/valid-id",
 			expectedStatus: http.StatusTemporaryRedirect,
 			expectedHeader: "http://example.com",
-			getURL: func(id string) (string, error) {
+			getURL: func(_ context.Context, id string) (storage.URLData, error) {
 				if id == "valid-id" {
-					return "http://example.com", nil
+					return storage.URLData{OriginalURL: "http://example.com"}, nil
 				}
-				return "", errors.New("invalid ID")
+				return storage.URLData{}, errors.New("invalid ID")
 			},
 		},
 		{
-			name:           "GET request with invalid ID",
+			name:           "GET request with permanent redirect type",
 			method:         http.MethodGet,
-			path:           "/invalid-id",
+			path:           "/moved",
+			expectedStatus: http.StatusMovedPermanently,
+			getURL: func(_ context.Context, id string) (storage.URLData, error) {
+				return storage.URLData{OriginalURL: "http://example.com", RedirectType: http.StatusMovedPermanently}, nil
+			},
+		},
+		{
+			name:           "GET request with expired link",
+			method:         http.MethodGet,
+			path:           "/expired",
+			expectedStatus: http.StatusGone,
+			getURL: func(_ context.Context, id string) (storage.URLData, error) {
+				expiresAt := time.Now().Add(-time.Hour)
+				return storage.URLData{OriginalURL: "http://example.com", ExpiresAt: &expiresAt}, nil
+			},
+		},
+		{
+			name:           "GET request with exhausted click limit",
+			method:         http.MethodGet,
+			path:           "/burned",
+			expectedStatus: http.StatusGone,
+			getURL: func(_ context.Context, id string) (storage.URLData, error) {
+				return storage.URLData{OriginalURL: "http://example.com", MaxClicks: 1, Clicks: 1}, nil
+			},
+			clickURL: func(_ context.Context, id string) (storage.URLData, error) {
+				return storage.URLData{}, storage.ErrClickLimitReached
+			},
+		},
+		{
+			name:           "POST request with negative max_clicks",
+			method:         http.MethodPost,
+			path:           "/api/shorten",
+			body:           map[string]interface{}{"url": "http://example.com", "max_clicks": -1},
 			expectedStatus: http.StatusBadRequest,
-			getURL: func(id string) (string, error) {
-				return "", errors.New("invalid ID")
+			expectedBody:   "max_clicks must not be negative",
+		},
+		{
+			name:           "GET request with unknown ID",
+			method:         http.MethodGet,
+			path:           "/unknown-id",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "url not found",
+			getURL: func(_ context.Context, id string) (storage.URLData, error) {
+				return storage.URLData{}, storage.ErrNotFound
+			},
+		},
+		{
+			name:           "GET request with storage failure",
+			method:         http.MethodGet,
+			path:           "/valid-id",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   "could not get URL",
+			getURL: func(_ context.Context, id string) (storage.URLData, error) {
+				return storage.URLData{}, errors.New("connection refused")
 			},
 		},
 		{
@@ -63,7 +139,7 @@ func TestShortenerRouter(t *testing.T) {
 			body:           "http://example.com",
 			expectedStatus: http.StatusCreated,
 			expectedBody:   config.Config.BaseURL,
-			shortURLAndStore: func(url string) (string, error) {
+			shortURLAndStore: func(_ context.Context, url string, _ models.LinkOptions) (string, error) {
 				if url == "http://example.com" {
 					return config.Config.BaseURL, nil
 				}
@@ -77,7 +153,7 @@ func TestShortenerRouter(t *testing.T) {
 			body:           "",
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   "could not read request body",
-			shortURLAndStore: func(url string) (string, error) {
+			shortURLAndStore: func(_ context.Context, url string, _ models.LinkOptions) (string, error) {
 				return "", nil
 			},
 		},
@@ -88,24 +164,55 @@ func TestShortenerRouter(t *testing.T) {
 			body:           map[string]string{"url": "http://example.com"},
 			expectedStatus: http.StatusCreated,
 			expectedBody:   `{"result":"` + config.Config.BaseURL + `"}`,
-			shortURLAndStore: func(url string) (string, error) {
+			shortURLAndStore: func(_ context.Context, url string, _ models.LinkOptions) (string, error) {
 				if url == "http://example.com" {
 					return config.Config.BaseURL, nil
 				}
 				return "", errors.New("could not store URL")
 			},
 		},
+		{
+			name:   "POST request with allow_duplicate",
+			method: http.MethodPost,
+			path:   "/api/shorten",
+			body: map[string]interface{}{
+				"url":             "http://example.com",
+				"allow_duplicate": true,
+				"tag":             "spring",
+				"metadata":        map[string]string{"channel": "email"},
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"result":"` + config.Config.BaseURL + `/campaign"}`,
+			shortURLAndStore: func(_ context.Context, url string, opts models.LinkOptions) (string, error) {
+				expected := models.LinkOptions{AllowDuplicate: true, Tag: "spring", Metadata: map[string]string{"channel": "email"}}
+				if url == "http://example.com" && reflect.DeepEqual(opts, expected) {
+					return config.Config.BaseURL + "/campaign", nil
+				}
+				return "", errors.New("could not store URL")
+			},
+		},
 		{
 			name:           "POST request with empty URL in JSON",
 			method:         http.MethodPost,
 			path:           "/api/shorten",
 			body:           map[string]string{"url": ""},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   "could not decode request body",
-			shortURLAndStore: func(url string) (string, error) {
+			expectedBody:   "url is required",
+			shortURLAndStore: func(_ context.Context, url string, _ models.LinkOptions) (string, error) {
 				return "", nil
 			},
 		},
+		{
+			name:           "POST request with invalid URL in JSON",
+			method:         http.MethodPost,
+			path:           "/api/shorten",
+			body:           map[string]string{"url": "ftp://example.com"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   utils.ErrInvalidURL.Error(),
+			shortURLAndStore: func(_ context.Context, url string, _ models.LinkOptions) (string, error) {
+				return "", utils.ErrInvalidURL
+			},
+		},
 		{
 			name:           "Invalid method (PUT request)",
 			method:         http.MethodPut,
@@ -121,7 +228,7 @@ func TestShortenerRouter(t *testing.T) {
 				switch v := tt.body.(type) {
 				case string:
 					reqBody = bytes.NewBufferString(v)
-				case map[string]string:
+				case map[string]string, map[string]interface{}:
 					jsonData, err := json.Marshal(v)
 					assert.NoError(t, err)
 					reqBody = bytes.NewBuffer(jsonData)
@@ -129,11 +236,21 @@ func TestShortenerRouter(t *testing.T) {
 			}
 
 			pingDB := func(ctx context.Context) error { return nil }
+			clickURL := tt.clickURL
+			if clickURL == nil {
+				clickURL = tt.getURL
+			}
 
 			req := httptest.NewRequest(tt.method, tt.path, reqBody)
 			recorder := httptest.NewRecorder()
 
-			router := ShortenerRouter(tt.shortURLAndStore, tt.getURL, tt.shortURLAndStoreBatch, pingDB)
+			router := ShortenerRouter(Handlers{
+				ShortURLAndStore:      tt.shortURLAndStore,
+				GetURL:                tt.getURL,
+				ClickURL:              clickURL,
+				ShortURLAndStoreBatch: tt.shortURLAndStoreBatch,
+				PingDB:                pingDB,
+			})
 			router.ServeHTTP(recorder, req)
 
 			assert.Equal(t, tt.expectedStatus, recorder.Code)
@@ -151,3 +268,1589 @@ func TestShortenerRouter(t *testing.T) {
 		})
 	}
 }
+
+func TestExportHandler(t *testing.T) {
+	var gotFormat export.Format
+	var gotFilter storage.ListFilter
+	router := ShortenerRouter(Handlers{
+		ExportURLs: func(_ context.Context, w io.Writer, format export.Format, filter storage.ListFilter) error {
+			gotFormat, gotFilter = format, filter
+			_, err := io.WriteString(w, "uuid,short_url,original_url,user_id,created_at\n")
+			return err
+		},
+	})
+
+	// без куки пользователя выгрузка недоступна, но кука выдаётся
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls/export", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	cookies := recorder.Result().Cookies()
+	assert.Len(t, cookies, 1)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/user/urls/export?format=csv&from=2024-01-01&to=2024-02-01T00:00:00Z", nil)
+	req.AddCookie(cookies[0])
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/csv", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, export.FormatCSV, gotFormat)
+	assert.NotEmpty(t, gotFilter.UserID)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), gotFilter.From)
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), gotFilter.To)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/user/urls/export?format=xml", nil)
+	req.AddCookie(cookies[0])
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestBrokenURLsHandler(t *testing.T) {
+	checkedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var gotUserID string
+	router := ShortenerRouter(Handlers{
+		BrokenURLs: func(_ context.Context, userID string) ([]storage.URLData, error) {
+			gotUserID = userID
+			return []storage.URLData{{
+				ShortURL:    "abc",
+				OriginalURL: "http://example.com/gone",
+				Health:      &storage.LinkHealth{Status: http.StatusNotFound, LatencyMS: 42, CheckedAt: checkedAt},
+			}}, nil
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls/broken", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/user/urls/broken", nil)
+	req.AddCookie(recorder.Result().Cookies()[0])
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotEmpty(t, gotUserID)
+	assert.JSONEq(t, `[{
+		"short_url": "`+config.Config.BaseURL+`/abc",
+		"original_url": "http://example.com/gone",
+		"status": 404,
+		"latency_ms": 42,
+		"checked_at": "2024-03-01T12:00:00Z"
+	}]`, recorder.Body.String())
+}
+
+func TestUpdateURLHandler(t *testing.T) {
+	var gotUpdate storage.URLUpdate
+	router := ShortenerRouter(Handlers{
+		UpdateURL: func(ctx context.Context, key string, upd storage.URLUpdate) (storage.URLData, error) {
+			gotUpdate = upd
+			switch key {
+			case "missing":
+				return storage.URLData{}, storage.ErrNotFound
+			case "foreign":
+				return storage.URLData{}, storage.ErrForbidden
+			case "taken":
+				return storage.URLData{}, &storage.ErrURLExists{ExistingShortURL: "other"}
+			}
+			data := storage.URLData{ShortURL: key, OriginalURL: "http://example.com"}
+			if upd.OriginalURL != nil {
+				data.OriginalURL = *upd.OriginalURL
+			}
+			if upd.RedirectType != nil {
+				data.RedirectType = *upd.RedirectType
+			}
+			return data, nil
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/urls/abc", bytes.NewBufferString(`{"original_url":"http://example.org"}`))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	cookie := recorder.Result().Cookies()[0]
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "new destination",
+			path:           "/api/urls/abc",
+			body:           `{"original_url":"http://example.org","redirect_type":301}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"original_url":"http://example.org","redirect_type":301`,
+		},
+		{
+			name:           "unsupported redirect type",
+			path:           "/api/urls/abc",
+			body:           `{"redirect_type":200}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "empty update",
+			path:           "/api/urls/abc",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown key",
+			path:           "/api/urls/missing",
+			body:           `{"expires_at":null}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "foreign link",
+			path:           "/api/urls/foreign",
+			body:           `{"expires_at":"2030-01-01T00:00:00Z"}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "destination already shortened",
+			path:           "/api/urls/taken",
+			body:           `{"original_url":"http://example.org"}`,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   "/other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, tt.path, bytes.NewBufferString(tt.body))
+			req.AddCookie(cookie)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+			if tt.expectedBody != "" {
+				assert.Contains(t, recorder.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+
+	req = httptest.NewRequest(http.MethodPatch, "/api/urls/abc", bytes.NewBufferString(`{"expires_at":null}`))
+	req.AddCookie(cookie)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, gotUpdate.ClearExpiry)
+	assert.Nil(t, gotUpdate.OriginalURL)
+}
+
+func TestUnlockHandler(t *testing.T) {
+	hash, err := utils.HashPassword("secret")
+	assert.NoError(t, err)
+	link := storage.URLData{ShortURL: "locked", OriginalURL: "http://example.com", PasswordHash: hash}
+
+	router := ShortenerRouter(Handlers{
+		GetURL: func(_ context.Context, key string) (storage.URLData, error) {
+			return link, nil
+		},
+		UnlockURL: func(_ context.Context, key, password string) (storage.URLData, error) {
+			if err := utils.CheckPassword(link.PasswordHash, password); err != nil {
+				return storage.URLData{}, err
+			}
+			return link, nil
+		},
+	})
+
+	// вместо редиректа отдаётся форма
+	req := httptest.NewRequest(http.MethodGet, "/locked", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, recorder.Body.String(), `name="password"`)
+	assert.Empty(t, recorder.Header().Get("Location"))
+
+	unlock := func(password, remoteAddr string) *httptest.ResponseRecorder {
+		form := "password=" + password
+		req := httptest.NewRequest(http.MethodPost, "/locked", bytes.NewBufferString(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder = unlock("secret", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusSeeOther, recorder.Code)
+	assert.Equal(t, "http://example.com", recorder.Header().Get("Location"))
+
+	for i := 0; i < unlockMaxFailures; i++ {
+		recorder = unlock("wrong", "192.0.2.2:1234")
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	}
+
+	// после серии ошибок даже верный пароль не принимается с этого адреса
+	recorder = unlock("secret", "192.0.2.2:4321")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+
+	recorder = unlock("secret", "192.0.2.3:1234")
+	assert.Equal(t, http.StatusSeeOther, recorder.Code)
+}
+
+func TestUnlockLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newUnlockLimiter(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	_, ok := limiter.tryAcquire("key|ip")
+	assert.True(t, ok)
+	_, ok = limiter.tryAcquire("key|ip")
+	assert.True(t, ok)
+	wait, ok := limiter.tryAcquire("key|ip")
+	assert.False(t, ok)
+	assert.Equal(t, time.Minute, wait)
+
+	now = now.Add(time.Minute)
+	_, ok = limiter.tryAcquire("key|ip")
+	assert.True(t, ok)
+
+	// отменённая попытка не считается ошибкой
+	limiter.release("key|ip")
+	_, ok = limiter.tryAcquire("key|ip")
+	assert.True(t, ok)
+	_, ok = limiter.tryAcquire("key|ip")
+	assert.True(t, ok)
+
+	limiter.reset("key|ip")
+	_, ok = limiter.tryAcquire("key|ip")
+	assert.True(t, ok)
+}
+
+func TestUnlockHandler_Concurrent(t *testing.T) {
+	const attempts = 20
+	var checked atomic.Int32
+	proceed := make(chan struct{})
+	router := ShortenerRouter(Handlers{
+		// медленное сравнение bcrypt: все проверки пароля ждут proceed
+		UnlockURL: func(context.Context, string, string) (storage.URLData, error) {
+			checked.Add(1)
+			<-proceed
+			return storage.URLData{}, utils.ErrWrongPassword
+		},
+	})
+
+	var rejected atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/locked", strings.NewReader("password=guess"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code == http.StatusTooManyRequests {
+				rejected.Add(1)
+			}
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		return checked.Load()+rejected.Load() == attempts
+	}, 5*time.Second, time.Millisecond)
+	close(proceed)
+	wg.Wait()
+
+	assert.EqualValues(t, unlockMaxFailures, checked.Load())
+	assert.EqualValues(t, attempts-unlockMaxFailures, rejected.Load())
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(2, 3)
+	limiter.now = func() time.Time { return now }
+
+	for i := 2; i >= 0; i-- {
+		d := limiter.allow("client")
+		assert.True(t, d.allowed)
+		assert.Equal(t, i, d.remaining)
+	}
+	d := limiter.allow("client")
+	assert.False(t, d.allowed)
+	assert.Equal(t, 500*time.Millisecond, d.retryAfter)
+	assert.Equal(t, 1500*time.Millisecond, d.reset)
+	assert.True(t, limiter.allow("other").allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, limiter.allow("client").allowed)
+	assert.False(t, limiter.allow("client").allowed)
+
+	// восстановившиеся корзины удаляются при очередной чистке
+	now = now.Add(rateLimitSweepInterval)
+	limiter.allow("client")
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter := newRateLimiter(1, 2)
+	handler := rateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := request("192.0.2.1:1234")
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, "2", recorder.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", recorder.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", recorder.Header().Get("RateLimit-Reset"))
+
+	assert.Equal(t, http.StatusNoContent, request("192.0.2.1:4321").Code)
+	recorder = request("192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusNoContent, request("192.0.2.2:1234").Code)
+}
+
+func TestRateLimit_Router(t *testing.T) {
+	rate, burst := config.Config.CreateRateLimit, config.Config.CreateRateBurst
+	defer func() {
+		config.Config.CreateRateLimit, config.Config.CreateRateBurst = rate, burst
+	}()
+	config.Config.CreateRateLimit, config.Config.CreateRateBurst = 1, 1
+
+	router := ShortenerRouter(Handlers{
+		ShortURLAndStore: func(context.Context, string, models.LinkOptions) (string, error) {
+			return "http://localhost:8080/abc", nil
+		},
+		PingDB: func(context.Context) error { return nil },
+		GetURL: func(_ context.Context, key string) (storage.URLData, error) {
+			return storage.URLData{ShortURL: key, OriginalURL: "http://example.com"}, nil
+		},
+		ClickURL: func(_ context.Context, key string) (storage.URLData, error) {
+			return storage.URLData{ShortURL: key, OriginalURL: "http://example.com"}, nil
+		},
+	})
+
+	shorten := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("http://example.com"))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	assert.Equal(t, http.StatusCreated, shorten())
+	assert.Equal(t, http.StatusTooManyRequests, shorten())
+
+	// свежая кука, выданная на другом маршруте, не даёт новой корзины
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("http://example.com"))
+	req.AddCookie(recorder.Result().Cookies()[0])
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+
+	// переходы ограничиваются отдельно
+	req = httptest.NewRequest(http.MethodGet, "/abc", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := config.Config.TrustedProxies
+	defer func() { config.Config.TrustedProxies = proxies }()
+	trusted, err := config.ParseTrustedProxies("10.0.0.0/8, 192.0.2.10")
+	assert.NoError(t, err)
+	config.Config.TrustedProxies = trusted
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{name: "direct client", remoteAddr: "198.51.100.1:1234", expected: "198.51.100.1"},
+		{name: "untrusted peer with spoofed header", remoteAddr: "198.51.100.1:1234", forwarded: []string{"203.0.113.5"}, expected: "198.51.100.1"},
+		{name: "trusted proxy", remoteAddr: "192.0.2.10:1234", forwarded: []string{"203.0.113.5"}, expected: "203.0.113.5"},
+		{name: "proxy chain", remoteAddr: "10.0.0.1:1234", forwarded: []string{"1.1.1.1, 203.0.113.5", "10.0.0.2"}, expected: "203.0.113.5"},
+		{name: "only proxies", remoteAddr: "10.0.0.1:1234", forwarded: []string{"10.0.0.3"}, expected: "10.0.0.3"},
+		{name: "garbage in chain", remoteAddr: "10.0.0.1:1234", forwarded: []string{"203.0.113.5, bogus"}, expected: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tt.expected, clientIP(req))
+		})
+	}
+}
+
+func TestRequestLogFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	router := logger.LoggingMiddleware(zap.New(core))(ShortenerRouter(Handlers{
+		GetURL: func(ctx context.Context, key string) (storage.URLData, error) {
+			logger.FromContext(ctx).Debug("get url")
+			return storage.URLData{ShortURL: key, OriginalURL: "http://example.com"}, nil
+		},
+		ClickURL: func(_ context.Context, key string) (storage.URLData, error) {
+			return storage.URLData{ShortURL: key, OriginalURL: "http://example.com"}, nil
+		},
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set(logger.RequestIDHeader, "req-1")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+	assert.Equal(t, "req-1", recorder.Header().Get(logger.RequestIDHeader))
+
+	entries := logs.AllUntimed()
+	if assert.Len(t, entries, 2) {
+		for _, entry := range entries {
+			fields := entry.ContextMap()
+			assert.Equal(t, "req-1", fields["request_id"])
+			assert.Equal(t, "203.0.113.7", fields["remote_ip"])
+			assert.Equal(t, "abc", fields["short_key"])
+			assert.NotEmpty(t, fields["user_id"])
+		}
+	}
+}
+
+func TestPreviewHandler(t *testing.T) {
+	createdAt := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	expiresAt := time.Now().Add(time.Hour)
+	links := map[string]storage.URLData{
+		"open":     {ShortURL: "open", OriginalURL: "http://example.com/<script>", CreatedAt: createdAt, Clicks: 7},
+		"locked":   {ShortURL: "locked", OriginalURL: "http://example.com/secret", PasswordHash: "hash"},
+		"burned":   {ShortURL: "burned", OriginalURL: "http://example.com", MaxClicks: 1, Clicks: 1},
+		"limited":  {ShortURL: "limited", OriginalURL: "http://example.com/limited", MaxClicks: 5, Clicks: 2},
+		"expiring": {ShortURL: "expiring", OriginalURL: "http://example.com/expiring", ExpiresAt: &expiresAt},
+		"banned":   {ShortURL: "banned", OriginalURL: "http://example.com/banned", Disabled: true},
+	}
+	clicked := false
+	router := ShortenerRouter(Handlers{
+		GetURL: func(_ context.Context, key string) (storage.URLData, error) {
+			data, ok := links[key]
+			if !ok {
+				return storage.URLData{}, storage.ErrNotFound
+			}
+			return data, nil
+		},
+		ClickURL: func(_ context.Context, key string) (storage.URLData, error) {
+			clicked = true
+			return links[key], nil
+		},
+	})
+
+	preview := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	recorder := preview("/open+")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/html")
+	body := recorder.Body.String()
+	assert.Contains(t, body, "http://example.com/&lt;script&gt;")
+	assert.NotContains(t, body, "<script>")
+	assert.Contains(t, body, "5 Mar 2024")
+	assert.Contains(t, body, "<dd>7</dd>")
+	assert.Contains(t, body, `href="`+config.Config.BaseURL+`/open"`)
+	assert.False(t, clicked, "preview must not count a click")
+
+	body = preview("/locked+").Body.String()
+	assert.NotContains(t, body, "secret")
+	assert.Contains(t, body, "password protected")
+
+	body = preview("/burned+").Body.String()
+	assert.Contains(t, body, "click limit")
+	assert.NotContains(t, body, "Continue")
+
+	// адрес ссылок с лимитом, сроком действия или отключённых не раскрывается
+	for _, key := range []string{"limited", "expiring"} {
+		recorder = preview("/" + key + "+")
+		assert.Equal(t, http.StatusOK, recorder.Code, key)
+		assert.NotContains(t, recorder.Body.String(), "http://example.com/"+key, key)
+		assert.Contains(t, recorder.Body.String(), "Hidden", key)
+	}
+	recorder = preview("/banned+")
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "http://example.com/banned")
+
+	assert.Equal(t, http.StatusNotFound, preview("/missing+").Code)
+
+	// обычный переход по ключу работает как раньше
+	recorder = preview("/open")
+	assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+	assert.True(t, clicked)
+}
+
+func TestQRHandler(t *testing.T) {
+	expiredAt := time.Now().Add(-time.Hour)
+	links := map[string]storage.URLData{
+		"abc":     {ShortURL: "abc", OriginalURL: "http://example.com"},
+		"banned":  {ShortURL: "banned", OriginalURL: "http://example.com/banned", Disabled: true},
+		"expired": {ShortURL: "expired", OriginalURL: "http://example.com/expired", ExpiresAt: &expiredAt},
+	}
+	router := ShortenerRouter(Handlers{
+		GetURL: func(_ context.Context, key string) (storage.URLData, error) {
+			data, ok := links[key]
+			if !ok {
+				return storage.URLData{}, storage.ErrNotFound
+			}
+			return data, nil
+		},
+	})
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := get("/abc/qr?size=128", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "image/png", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Header().Get("Cache-Control"), "max-age=")
+	etag := recorder.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, []byte("\x89PNG"), recorder.Body.Bytes()[:4])
+
+	recorder = get("/abc/qr?size=128", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+
+	recorder = get("/abc/qr?format=svg&ecc=H&quiet_zone=2", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "image/svg+xml", recorder.Header().Get("Content-Type"))
+	assert.NotEqual(t, etag, recorder.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusNotFound, get("/missing/qr", nil).Code)
+	assert.Equal(t, http.StatusNotFound, get("/banned/qr", nil).Code)
+	assert.Equal(t, http.StatusGone, get("/expired/qr", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, get("/abc/qr?format=gif", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, get("/abc/qr?size=10", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, get("/abc/qr?ecc=Z", nil).Code)
+}
+
+func TestRedirectHandler_Crawler(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	links := map[string]storage.URLData{
+		"sale": {
+			ShortURL: "sale", OriginalURL: "http://example.com/sale",
+			PageMeta: &storage.PageMeta{Title: `Spring "sale"`, Description: "Everything must go", Image: "http://example.com/banner.png"},
+		},
+		"plain":  {ShortURL: "plain", OriginalURL: "http://example.com/plain"},
+		"fresh":  {ShortURL: "fresh", OriginalURL: "http://example.com/fresh", MaxClicks: 1},
+		"burned": {ShortURL: "burned", OriginalURL: "http://example.com/burned", MaxClicks: 1, Clicks: 1},
+		"limited": {
+			ShortURL: "limited", OriginalURL: "http://example.com/limited", MaxClicks: 3,
+			PageMeta: &storage.PageMeta{Title: "Limited offer"},
+		},
+		"expiring": {ShortURL: "expiring", OriginalURL: "http://example.com/expiring", ExpiresAt: &expiresAt},
+	}
+	clicks := 0
+	router := ShortenerRouter(Handlers{
+		GetURL: func(_ context.Context, key string) (storage.URLData, error) {
+			return links[key], nil
+		},
+		ClickURL: func(_ context.Context, key string) (storage.URLData, error) {
+			clicks++
+			return links[key], nil
+		},
+	})
+
+	get := func(path, userAgent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", userAgent)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	const slackbot = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+
+	recorder := get("/sale", slackbot)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	assert.Contains(t, body, `<meta property="og:title" content="Spring &#34;sale&#34;">`)
+	assert.Contains(t, body, `<meta property="og:image" content="http://example.com/banner.png">`)
+	assert.Contains(t, body, `<meta property="og:url" content="`+config.Config.BaseURL+`/sale">`)
+
+	// без сведений о странице бот получает обычный редирект, но клик не учитывается
+	recorder = get("/plain", "TelegramBot (like TwitterBot)")
+	assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+	assert.Equal(t, "http://example.com/plain", recorder.Header().Get("Location"))
+
+	// адрес ограниченных ссылок бот не получает ни редиректом, ни в разметке
+	for _, key := range []string{"fresh", "limited", "expiring"} {
+		recorder = get("/"+key, slackbot)
+		assert.Equal(t, http.StatusOK, recorder.Code, key)
+		assert.Empty(t, recorder.Header().Get("Location"), key)
+		assert.NotContains(t, recorder.Body.String(), "http://example.com/"+key, key)
+		assert.Contains(t, recorder.Body.String(), `<link rel="canonical" href="`+config.Config.BaseURL+"/"+key+`">`, key)
+	}
+	assert.Contains(t, get("/limited", slackbot).Body.String(), `<meta property="og:title" content="Limited offer">`)
+	assert.Equal(t, http.StatusGone, get("/burned", slackbot).Code)
+	assert.Zero(t, clicks)
+
+	recorder = get("/sale", "Mozilla/5.0")
+	assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+	assert.Equal(t, 1, clicks)
+}
+
+func TestAPIKeys(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var created storage.APIKey
+	router := ShortenerRouter(Handlers{
+		ShortURLAndStore: func(context.Context, string, models.LinkOptions) (string, error) {
+			return "http://localhost:8080/abc", nil
+		},
+		ExportURLs: func(ctx context.Context, w io.Writer, _ export.Format, filter storage.ListFilter) error {
+			_, err := io.WriteString(w, filter.UserID)
+			return err
+		},
+		CreateAPIKey: func(ctx context.Context, name string, scopes []string) (storage.APIKey, string, error) {
+			created = storage.APIKey{ID: "id-1", UserID: auth.UserIDFromContext(ctx), Name: name, Prefix: "sk_1234", Hash: "hash", Scopes: scopes, CreatedAt: createdAt}
+			return created, "sk_1234secret", nil
+		},
+		ListAPIKeys: func(ctx context.Context) ([]storage.APIKey, error) {
+			return []storage.APIKey{created}, nil
+		},
+		DeleteAPIKey: func(_ context.Context, id string) error {
+			if id != created.ID {
+				return storage.ErrAPIKeyNotFound
+			}
+			return nil
+		},
+		LookupAPIKey: func(_ context.Context, token string) (auth.Identity, error) {
+			if token != "sk_reader" {
+				return auth.Identity{}, auth.ErrInvalidAPIKey
+			}
+			return auth.Identity{UserID: "owner", APIKeyID: "id-0", Scopes: []string{auth.ScopeRead}}, nil
+		},
+	})
+
+	do := func(method, path, body, token string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// ключ определяет пользователя для выгрузки
+	recorder := do(http.MethodGet, "/api/user/urls/export", "", "sk_reader", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "owner", recorder.Body.String())
+	assert.Empty(t, recorder.Result().Cookies())
+
+	recorder = do(http.MethodPost, "/", "http://example.com", "sk_reader", nil)
+	assert.Equal(t, http.StatusForbidden, recorder.Code, "key without shorten scope")
+
+	recorder = do(http.MethodGet, "/api/user/urls/export", "", "sk_revoked", nil)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("WWW-Authenticate"))
+	assert.Empty(t, recorder.Result().Cookies())
+
+	recorder = do(http.MethodGet, "/api/keys", "", "sk_reader", nil)
+	assert.Equal(t, http.StatusForbidden, recorder.Code, "keys cannot manage keys")
+
+	recorder = do(http.MethodPost, "/api/keys", `{"scopes":["shorten"]}`, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	cookie := recorder.Result().Cookies()[0]
+
+	recorder = do(http.MethodPost, "/api/keys", `{"scopes":["admin"]}`, "", cookie)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	recorder = do(http.MethodPost, "/api/keys", `{"name":"ci"}`, "", cookie)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = do(http.MethodPost, "/api/keys", `{"name":"ci","scopes":["shorten","read"]}`, "", cookie)
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.JSONEq(t, `{
+		"id": "id-1",
+		"name": "ci",
+		"prefix": "sk_1234",
+		"scopes": ["shorten", "read"],
+		"created_at": "2024-01-01T00:00:00Z",
+		"key": "sk_1234secret"
+	}`, recorder.Body.String())
+	assert.NotEmpty(t, created.UserID)
+
+	recorder = do(http.MethodGet, "/api/keys", "", "", cookie)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "secret")
+	assert.NotContains(t, recorder.Body.String(), "hash")
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/keys/id-1", "", "", cookie).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/keys/id-2", "", "", cookie).Code)
+}
+
+func TestAdminAPI(t *testing.T) {
+	admins := config.Config.AdminUsers
+	defer func() { config.Config.AdminUsers = admins }()
+
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	links := map[string]storage.URLData{
+		"abc": {ShortURL: "abc", OriginalURL: "http://example.com/casino", UserID: "user0", CreatedAt: createdAt, Clicks: 3},
+	}
+	var gotFilter storage.ListFilter
+	router := ShortenerRouter(Handlers{
+		GetURL: func(_ context.Context, key string) (storage.URLData, error) {
+			data, ok := links[key]
+			if !ok {
+				return storage.URLData{}, storage.ErrNotFound
+			}
+			return data, nil
+		},
+		AdminSearch: func(_ context.Context, filter storage.ListFilter) ([]storage.URLData, error) {
+			gotFilter = filter
+			return []storage.URLData{links["abc"]}, nil
+		},
+		AdminSetDisabled: func(_ context.Context, key string, disabled bool) error {
+			data, ok := links[key]
+			if !ok {
+				return storage.ErrNotFound
+			}
+			data.Disabled = disabled
+			links[key] = data
+			return nil
+		},
+		AdminDelete: func(_ context.Context, key string) error {
+			if _, ok := links[key]; !ok {
+				return storage.ErrNotFound
+			}
+			delete(links, key)
+			return nil
+		},
+	})
+
+	do := func(method, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := do(http.MethodGet, "/api/admin/urls", nil)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	cookie := recorder.Result().Cookies()[0]
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/admin/urls", cookie).Code)
+
+	userID, _, _ := strings.Cut(cookie.Value, ".")
+	config.Config.AdminUsers = []string{userID}
+
+	recorder = do(http.MethodGet, "/api/admin/urls?q=casino&user_id=user0&from=2024-01-01&limit=10", cookie)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, storage.ListFilter{UserID: "user0", OriginalContains: "casino", From: createdAt, Limit: 10}, gotFilter)
+	assert.JSONEq(t, `[{
+		"id": "abc",
+		"short_url": "`+config.Config.BaseURL+`/abc",
+		"original_url": "http://example.com/casino",
+		"user_id": "user0",
+		"created_at": "2024-01-01T00:00:00Z",
+		"clicks": 3,
+		"disabled": false
+	}]`, recorder.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/admin/urls?limit=0", cookie).Code)
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "/api/admin/urls/abc/disable", cookie).Code)
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, do(http.MethodGet, "/abc", nil).Code)
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, do(http.MethodGet, "/abc+", nil).Code)
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "/api/admin/urls/abc/enable", cookie).Code)
+	assert.False(t, links["abc"].Disabled)
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/admin/urls/abc", cookie).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/admin/urls/abc", cookie).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/admin/urls/abc/disable", cookie).Code)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "identity", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "gzip, deflate, br", want: "br"},
+		{header: "gzip;q=1, br;q=0.5", want: "gzip"},
+		{header: "deflate;q=0.8, gzip;q=0.8", want: "gzip"},
+		{header: "gzip, deflate, br, zstd", want: "br"},
+		{header: "gzip, zstd", want: "zstd"},
+		{header: "br;q=0, *", want: "zstd"},
+		{header: "br;q=0, zstd;q=0, *", want: "gzip"},
+		{header: "*;q=0", want: ""},
+		{header: "GZIP; Q=0.5", want: "gzip"},
+		{header: "gzip;q=0", want: ""},
+		{header: "gzip;q=abc, deflate", want: "deflate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.header))
+		})
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	large := strings.Repeat(`{"short_url":"http://localhost:8080/abc"}`, 100)
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) {
+			return flate.NewReader(r), nil
+		},
+		"br": func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+	}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		status         int
+		wantEncoding   string
+	}{
+		{name: "gzip", acceptEncoding: "gzip", contentType: "application/json", body: large, wantEncoding: "gzip"},
+		{name: "deflate", acceptEncoding: "deflate", contentType: "application/json", body: large, wantEncoding: "deflate"},
+		{name: "brotli", acceptEncoding: "gzip;q=0.5, br", contentType: "text/html; charset=utf-8", body: large, wantEncoding: "br"},
+		{name: "zstd", acceptEncoding: "gzip, zstd", contentType: "application/x-ndjson", body: large, wantEncoding: "zstd"},
+		{name: "sniffed type", acceptEncoding: "gzip", body: large, wantEncoding: "gzip"},
+		{name: "error status", acceptEncoding: "gzip", contentType: "application/json", body: large, status: http.StatusBadRequest, wantEncoding: "gzip"},
+		{name: "small body", acceptEncoding: "gzip", contentType: "application/json", body: `{"result":"x"}`},
+		{name: "not compressible", acceptEncoding: "gzip", contentType: "image/png", body: large},
+		{name: "not accepted", acceptEncoding: "", contentType: "application/json", body: large},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := compressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				// тело пишется частями, чтобы решение принималось по накопленному буферу
+				for i := 0; i < len(tt.body); i += 100 {
+					end := i + 100
+					if end > len(tt.body) {
+						end = len(tt.body)
+					}
+					w.Write([]byte(tt.body[i:end]))
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			wantStatus := tt.status
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			assert.Equal(t, wantStatus, recorder.Code)
+			assert.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+			assert.Equal(t, tt.wantEncoding, recorder.Header().Get("Content-Encoding"))
+
+			var body io.Reader = recorder.Body
+			if tt.wantEncoding != "" {
+				assert.Less(t, recorder.Body.Len(), len(tt.body))
+				var err error
+				body, err = decoders[tt.wantEncoding](recorder.Body)
+				assert.NoError(t, err)
+			}
+			got, err := io.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.body, string(got))
+		})
+	}
+}
+
+func TestCompressionMiddleware_Pool(t *testing.T) {
+	body := strings.Repeat("a", 2*minCompressSize)
+	handler := compressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}))
+
+	// писатели из пула не должны переносить состояние между ответами
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		reader, err := gzip.NewReader(recorder.Body)
+		if assert.NoError(t, err) {
+			got, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, body, string(got))
+		}
+	}
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	maxBody, maxDecompressed := config.Config.MaxBodySize, config.Config.MaxDecompressedBodySize
+	defer func() {
+		config.Config.MaxBodySize, config.Config.MaxDecompressedBodySize = maxBody, maxDecompressed
+	}()
+	config.Config.MaxBodySize, config.Config.MaxDecompressedBodySize = 4096, 1024
+
+	router := ShortenerRouter(Handlers{
+		ShortURLAndStore: func(context.Context, string, models.LinkOptions) (string, error) {
+			return "http://localhost:8080/abc", nil
+		},
+	})
+
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+		return buf.Bytes()
+	}
+	longURL := `{"url":"http://example.com/` + strings.Repeat("a", 100000) + `"}`
+
+	tests := []struct {
+		name     string
+		path     string
+		body     io.Reader
+		encoding string
+		wantCode int
+		wantBody string
+	}{
+		{name: "valid", path: "/api/shorten", body: strings.NewReader(`{"url":"http://example.com"} `), wantCode: http.StatusCreated},
+		{name: "unknown field", path: "/api/shorten", body: strings.NewReader(`{"url":"http://example.com","uri":"x"}`), wantCode: http.StatusBadRequest, wantBody: `unknown field "uri"`},
+		{name: "trailing data", path: "/api/shorten", body: strings.NewReader(`{"url":"http://example.com"}{}`), wantCode: http.StatusBadRequest, wantBody: "single JSON value"},
+		{name: "trailing garbage", path: "/api/shorten", body: strings.NewReader(`{"url":"http://example.com"} x`), wantCode: http.StatusBadRequest, wantBody: "single JSON value"},
+		{name: "empty body", path: "/api/shorten", body: strings.NewReader(""), wantCode: http.StatusBadRequest, wantBody: "request body is empty"},
+		{name: "content length too large", path: "/api/shorten", body: strings.NewReader(longURL), wantCode: http.StatusRequestEntityTooLarge, wantBody: "exceeds 4096 bytes"},
+		// без Content-Length предел срабатывает при чтении
+		{name: "streamed body too large", path: "/api/shorten/batch", body: struct{ io.Reader }{strings.NewReader(longURL)}, wantCode: http.StatusRequestEntityTooLarge, wantBody: "exceeds 4096 bytes"},
+		{name: "decompressed body too large", path: "/api/shorten", body: bytes.NewReader(gzipped(longURL)), encoding: "gzip", wantCode: http.StatusRequestEntityTooLarge, wantBody: "exceeds 1024 bytes"},
+		{name: "compressed body", path: "/api/shorten", body: bytes.NewReader(gzipped(`{"url":"http://example.com"}`)), encoding: "gzip", wantCode: http.StatusCreated},
+		{name: "corrupt gzip", path: "/api/shorten", body: strings.NewReader("not gzip"), encoding: "gzip", wantCode: http.StatusBadRequest, wantBody: "could not decompress gzip body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, tt.body)
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, problem.ContentType, recorder.Header().Get("Content-Type"))
+				var resp problem.Problem
+				assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+				assert.Contains(t, resp.Detail, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("plain text body too large", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipped(strings.Repeat("a", 2048))))
+		req.Header.Set("Content-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+		assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	})
+}
+
+func TestProblemResponses(t *testing.T) {
+	exists := func(context.Context, string, models.LinkOptions) (string, error) {
+		return "", &storage.ErrURLExists{ExistingShortURL: "abc"}
+	}
+	router := ShortenerRouter(Handlers{
+		ShortURLAndStore: exists,
+		GetURL: func(_ context.Context, key string) (storage.URLData, error) {
+			return storage.URLData{ShortURL: key, OriginalURL: "http://example.com", Disabled: true}, nil
+		},
+	})
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	decode := func(t *testing.T, recorder *httptest.ResponseRecorder) problem.Problem {
+		t.Helper()
+		assert.Equal(t, problem.ContentType, recorder.Header().Get("Content-Type"))
+		var p problem.Problem
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &p))
+		assert.Equal(t, recorder.Code, p.Status)
+		return p
+	}
+
+	t.Run("conflict keeps result", func(t *testing.T) {
+		recorder := serve(http.MethodPost, "/api/shorten", `{"url":"http://example.com"}`)
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		p := decode(t, recorder)
+		assert.Equal(t, problem.CodeURLExists, p.Code)
+		assert.Equal(t, config.Config.BaseURL+"/abc", p.Extensions["result"])
+	})
+
+	t.Run("conflict on text endpoint", func(t *testing.T) {
+		recorder := serve(http.MethodPost, "/", "http://example.com")
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, config.Config.BaseURL+"/abc", recorder.Body.String())
+	})
+
+	t.Run("text endpoint errors stay plain", func(t *testing.T) {
+		recorder := serve(http.MethodPost, "/", "")
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+		assert.Equal(t, "could not read request body\n", recorder.Body.String())
+	})
+
+	t.Run("disabled link", func(t *testing.T) {
+		recorder := serve(http.MethodGet, "/abc", "")
+		assert.Equal(t, http.StatusUnavailableForLegalReasons, recorder.Code)
+		assert.Equal(t, problem.CodeLinkDisabled, decode(t, recorder).Code)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		recorder := serve(http.MethodGet, "/api/user/urls/broken", "")
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, problem.CodeUnauthorized, decode(t, recorder).Code)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		recorder := serve(http.MethodPut, "/abc", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+		assert.Equal(t, problem.CodeMethodNotAllowed, decode(t, recorder).Code)
+	})
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	getURL := func(_ context.Context, key string) (storage.URLData, error) {
+		return storage.URLData{}, storage.ErrNotFound
+	}
+
+	tests := []struct {
+		name         string
+		notFound     http.Handler
+		wantStatus   int
+		wantLocation string
+		wantType     string
+		wantBody     string
+	}{
+		{name: "default", wantStatus: http.StatusNotFound, wantType: problem.ContentType, wantBody: "url not found"},
+		{name: "fallback redirect", notFound: NotFoundHandler("https://example.com/", nil), wantStatus: http.StatusFound, wantLocation: "https://example.com/"},
+		{name: "custom page", notFound: NotFoundHandler("", []byte("<h1>No such link</h1>")), wantStatus: http.StatusNotFound, wantType: "text/html; charset=utf-8", wantBody: "<h1>No such link</h1>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := ShortenerRouter(Handlers{GetURL: getURL, ClickURL: getURL, NotFound: tt.notFound})
+
+			for _, path := range []string{"/missing", "/missing+"} {
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+				assert.Equal(t, tt.wantStatus, recorder.Code, path)
+				assert.Equal(t, tt.wantLocation, recorder.Header().Get("Location"), path)
+				if tt.wantType != "" {
+					assert.Equal(t, tt.wantType, recorder.Header().Get("Content-Type"), path)
+				}
+				assert.Contains(t, recorder.Body.String(), tt.wantBody, path)
+			}
+		})
+	}
+}
+
+// openAPISpec — разобранный docs/openapi.json с минимальной проверкой
+// значений по JSON Schema: $ref, allOf, type, format date-time, nullable,
+// enum, required, properties, additionalProperties и items.
+type openAPISpec map[string]interface{}
+
+func loadOpenAPISpec(t *testing.T) openAPISpec {
+	t.Helper()
+	data, err := docsFS.ReadFile("docs/openapi.json")
+	require.NoError(t, err)
+	var spec openAPISpec
+	require.NoError(t, json.Unmarshal(data, &spec))
+	return spec
+}
+
+func (s openAPISpec) resolve(node map[string]interface{}) (map[string]interface{}, error) {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node, nil
+		}
+		var cur interface{} = map[string]interface{}(s)
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			m, _ := cur.(map[string]interface{})
+			cur = m[part]
+		}
+		next, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolved $ref %s", ref)
+		}
+		node = next
+	}
+}
+
+func (s openAPISpec) operation(method, path string) (map[string]interface{}, bool) {
+	paths, _ := s["paths"].(map[string]interface{})
+	item, _ := paths[path].(map[string]interface{})
+	op, ok := item[strings.ToLower(method)].(map[string]interface{})
+	return op, ok
+}
+
+func (s openAPISpec) validate(schema map[string]interface{}, value interface{}, at string) error {
+	schema, err := s.resolve(schema)
+	if err != nil {
+		return err
+	}
+
+	if parts, ok := schema["allOf"].([]interface{}); ok {
+		for _, part := range parts {
+			if err := s.validate(part.(map[string]interface{}), value, at); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			found = found || reflect.DeepEqual(v, value)
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: want object, got %T", at, value)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required field %q", at, name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, v := range obj {
+			if prop, ok := props[name].(map[string]interface{}); ok {
+				if err := s.validate(prop, v, at+"."+name); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unexpected field %q", at, name)
+				}
+			case map[string]interface{}:
+				if err := s.validate(extra, v, at+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: want array, got %T", at, value)
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, v := range arr {
+			if err := s.validate(items, v, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: want string, got %T", at, value)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: %w", at, err)
+			}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: want integer, got %v", at, value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: want number, got %T", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: want boolean, got %T", at, value)
+		}
+	}
+	return nil
+}
+
+// checkResponse проверяет, что статус, Content-Type и тело ответа описаны
+// в операции method path.
+func (s openAPISpec) checkResponse(method, path string, rec *httptest.ResponseRecorder) error {
+	op, ok := s.operation(method, path)
+	if !ok {
+		return fmt.Errorf("operation %s %s is not documented", method, path)
+	}
+	responses, _ := op["responses"].(map[string]interface{})
+	node, ok := responses[strconv.Itoa(rec.Code)].(map[string]interface{})
+	if !ok {
+		node, ok = responses["default"].(map[string]interface{})
+	}
+	if !ok {
+		return fmt.Errorf("status %d is not documented", rec.Code)
+	}
+	resp, err := s.resolve(node)
+	if err != nil {
+		return err
+	}
+
+	content, _ := resp["content"].(map[string]interface{})
+	if len(content) == 0 {
+		if rec.Body.Len() != 0 {
+			return fmt.Errorf("status %d is documented without a body, got %q", rec.Code, rec.Body.String())
+		}
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("bad Content-Type: %w", err)
+	}
+	media, ok := content[mediaType].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("content type %s is not documented for status %d", mediaType, rec.Code)
+	}
+	schema, _ := media["schema"].(map[string]interface{})
+
+	switch {
+	case mediaType == "application/x-ndjson":
+		scanner := bufio.NewScanner(bytes.NewReader(rec.Body.Bytes()))
+		for i := 0; scanner.Scan(); i++ {
+			var v interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+				return fmt.Errorf("line %d: %w", i, err)
+			}
+			if err := s.validate(schema, v, fmt.Sprintf("line %d", i)); err != nil {
+				return err
+			}
+		}
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+			return fmt.Errorf("body is not JSON: %w", err)
+		}
+		return s.validate(schema, v, "body")
+	}
+	return nil
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+
+	var documented []string
+	paths, _ := spec["paths"].(map[string]interface{})
+	for path, item := range paths {
+		for method := range item.(map[string]interface{}) {
+			if method != "parameters" {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+
+	var routed []string
+	err := chi.Walk(ShortenerRouter(Handlers{}).(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		// подстановочный конец маршрута chi описан как параметр path
+		routed = append(routed, method+" "+strings.Replace(route, "/*", "/{path}", 1))
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, routed, documented)
+}
+
+func TestOpenAPIConformance(t *testing.T) {
+	admins, createRate, redirectRate := config.Config.AdminUsers, config.Config.CreateRateLimit, config.Config.RedirectRateLimit
+	defer func() {
+		config.Config.AdminUsers, config.Config.CreateRateLimit, config.Config.RedirectRateLimit = admins, createRate, redirectRate
+	}()
+	config.Config.CreateRateLimit, config.Config.RedirectRateLimit = 0, 0
+
+	spec := loadOpenAPISpec(t)
+
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiredAt := createdAt.Add(time.Hour)
+	links := map[string]storage.URLData{
+		"abc":      {UUID: "1", ShortURL: "abc", OriginalURL: "http://example.com", UserID: "user0", CreatedAt: createdAt, Clicks: 3, Tag: "docs"},
+		"locked":   {ShortURL: "locked", OriginalURL: "http://example.com/secret", PasswordHash: "hash"},
+		"expired":  {ShortURL: "expired", OriginalURL: "http://example.com/old", ExpiresAt: &expiredAt},
+		"disabled": {ShortURL: "disabled", OriginalURL: "http://example.com/spam", Disabled: true},
+	}
+	getURL := func(_ context.Context, key string) (storage.URLData, error) {
+		if key == "broken" {
+			return storage.URLData{}, errors.New("connection refused")
+		}
+		data, ok := links[key]
+		if !ok {
+			return storage.URLData{}, storage.ErrNotFound
+		}
+		return data, nil
+	}
+	exportStore := storage.NewInMemoryStore()
+	_, err := exportStore.Save(context.Background(), links["abc"])
+	require.NoError(t, err)
+
+	router := ShortenerRouter(Handlers{
+		ShortURLAndStore: func(_ context.Context, originalURL string, _ models.LinkOptions) (string, error) {
+			if originalURL == "http://exists.example" {
+				return "", &storage.ErrURLExists{ExistingShortURL: "abc"}
+			}
+			return config.Config.BaseURL + "/abc", nil
+		},
+		ShortURLAndStoreBatch: func(_ context.Context, items []models.RequestPayloadBatch) ([]models.BatchItem, error) {
+			resp := make([]models.BatchItem, len(items))
+			for i, item := range items {
+				if item.OriginalURL == "http://exists.example" {
+					return nil, &storage.ErrURLExists{ID: item.CorrelationID, ExistingShortURL: "abc"}
+				}
+				resp[i] = models.BatchItem{CorrelationID: item.CorrelationID, ShortURL: config.Config.BaseURL + "/abc"}
+			}
+			return resp, nil
+		},
+		GetURL:   getURL,
+		ClickURL: getURL,
+		UnlockURL: func(ctx context.Context, key, password string) (storage.URLData, error) {
+			if key == "locked" && password != "secret" {
+				return storage.URLData{}, utils.ErrWrongPassword
+			}
+			return getURL(ctx, key)
+		},
+		PingDB: func(context.Context) error { return nil },
+		ExportURLs: func(ctx context.Context, w io.Writer, format export.Format, _ storage.ListFilter) error {
+			return export.Write(ctx, w, format, exportStore, storage.ListFilter{})
+		},
+		UpdateURL: func(_ context.Context, key string, _ storage.URLUpdate) (storage.URLData, error) {
+			if key == "other" {
+				return storage.URLData{}, storage.ErrForbidden
+			}
+			data, ok := links[key]
+			if !ok {
+				return storage.URLData{}, storage.ErrNotFound
+			}
+			data.RedirectType = http.StatusMovedPermanently
+			data.ExpiresAt = &expiredAt
+			return data, nil
+		},
+		URLHistory: func(_ context.Context, key string) ([]storage.HistoryEntry, error) {
+			return []storage.HistoryEntry{{ShortURL: key, OriginalURL: "http://example.org", ChangedBy: "user0", ChangedAt: createdAt}}, nil
+		},
+		BrokenURLs: func(context.Context, string) ([]storage.URLData, error) {
+			data := links["abc"]
+			data.Health = &storage.LinkHealth{Status: http.StatusBadGateway, LatencyMS: 120, CheckedAt: createdAt}
+			return []storage.URLData{data}, nil
+		},
+		CreateAPIKey: func(_ context.Context, name string, scopes []string) (storage.APIKey, string, error) {
+			return storage.APIKey{ID: "id-1", Name: name, Prefix: "sk_1234", Scopes: scopes, CreatedAt: createdAt}, "sk_1234secret", nil
+		},
+		ListAPIKeys: func(context.Context) ([]storage.APIKey, error) {
+			return []storage.APIKey{{ID: "id-1", Prefix: "sk_1234", Scopes: []string{auth.ScopeRead}, CreatedAt: createdAt}}, nil
+		},
+		DeleteAPIKey: func(_ context.Context, id string) error {
+			if id != "id-1" {
+				return storage.ErrAPIKeyNotFound
+			}
+			return nil
+		},
+		LookupAPIKey: func(_ context.Context, token string) (auth.Identity, error) {
+			if token != "sk_reader" {
+				return auth.Identity{}, auth.ErrInvalidAPIKey
+			}
+			return auth.Identity{UserID: "owner", APIKeyID: "id-0", Scopes: []string{auth.ScopeRead}}, nil
+		},
+		AdminSearch: func(context.Context, storage.ListFilter) ([]storage.URLData, error) {
+			return []storage.URLData{links["abc"]}, nil
+		},
+		AdminSetDisabled: func(_ context.Context, key string, _ bool) error {
+			if _, ok := links[key]; !ok {
+				return storage.ErrNotFound
+			}
+			return nil
+		},
+		AdminDelete: func(_ context.Context, key string) error {
+			if _, ok := links[key]; !ok {
+				return storage.ErrNotFound
+			}
+			return nil
+		},
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/keys", nil))
+	session := recorder.Result().Cookies()[0]
+	userID, _, _ := strings.Cut(session.Value, ".")
+	config.Config.AdminUsers = []string{userID}
+
+	tests := []struct {
+		name        string
+		operation   string
+		method      string
+		target      string
+		contentType string
+		body        string
+		token       string
+		anonymous   bool
+		wantStatus  int
+	}{
+		{name: "root", operation: "GET /", method: http.MethodGet, target: "/", wantStatus: http.StatusNotFound},
+		{name: "shorten text", operation: "POST /", method: http.MethodPost, target: "/", contentType: "text/plain", body: "http://example.com", wantStatus: http.StatusCreated},
+		{name: "shorten text exists", operation: "POST /", method: http.MethodPost, target: "/", contentType: "text/plain", body: "http://exists.example", wantStatus: http.StatusConflict},
+		{name: "shorten text empty", operation: "POST /", method: http.MethodPost, target: "/", contentType: "text/plain", wantStatus: http.StatusBadRequest},
+		{name: "ping", operation: "GET /ping", method: http.MethodGet, target: "/ping", wantStatus: http.StatusOK},
+
+		{name: "redirect", operation: "GET /{id}", method: http.MethodGet, target: "/abc", wantStatus: http.StatusTemporaryRedirect},
+		{name: "redirect password form", operation: "GET /{id}", method: http.MethodGet, target: "/locked", wantStatus: http.StatusOK},
+		{name: "redirect unknown", operation: "GET /{id}", method: http.MethodGet, target: "/missing", wantStatus: http.StatusNotFound},
+		{name: "redirect expired", operation: "GET /{id}", method: http.MethodGet, target: "/expired", wantStatus: http.StatusGone},
+		{name: "redirect disabled", operation: "GET /{id}", method: http.MethodGet, target: "/disabled", wantStatus: http.StatusUnavailableForLegalReasons},
+		{name: "redirect storage failure", operation: "GET /{id}", method: http.MethodGet, target: "/broken", wantStatus: http.StatusInternalServerError},
+		{name: "unlock", operation: "POST /{id}", method: http.MethodPost, target: "/locked", contentType: "application/x-www-form-urlencoded", body: "password=secret", wantStatus: http.StatusSeeOther},
+		{name: "unlock wrong password", operation: "POST /{id}", method: http.MethodPost, target: "/locked", contentType: "application/x-www-form-urlencoded", body: "password=guess", wantStatus: http.StatusUnauthorized},
+		{name: "unlock unknown", operation: "POST /{id}", method: http.MethodPost, target: "/missing", contentType: "application/x-www-form-urlencoded", body: "password=secret", wantStatus: http.StatusNotFound},
+		{name: "preview", operation: "GET /{id}+", method: http.MethodGet, target: "/abc+", wantStatus: http.StatusOK},
+		{name: "preview unknown", operation: "GET /{id}+", method: http.MethodGet, target: "/missing+", wantStatus: http.StatusNotFound},
+		{name: "qr png", operation: "GET /{id}/qr", method: http.MethodGet, target: "/abc/qr", wantStatus: http.StatusOK},
+		{name: "qr svg", operation: "GET /{id}/qr", method: http.MethodGet, target: "/abc/qr?format=svg&ecc=H&size=128&quiet_zone=2", wantStatus: http.StatusOK},
+		{name: "qr bad size", operation: "GET /{id}/qr", method: http.MethodGet, target: "/abc/qr?size=0", wantStatus: http.StatusBadRequest},
+		{name: "qr unknown", operation: "GET /{id}/qr", method: http.MethodGet, target: "/missing/qr", wantStatus: http.StatusNotFound},
+
+		{name: "shorten", operation: "POST /api/shorten", method: http.MethodPost, target: "/api/shorten", contentType: "application/json", body: `{"url":"http://example.com","tag":"docs","metadata":{"a":"b"},"max_clicks":5}`, wantStatus: http.StatusCreated},
+		{name: "shorten exists", operation: "POST /api/shorten", method: http.MethodPost, target: "/api/shorten", contentType: "application/json", body: `{"url":"http://exists.example"}`, wantStatus: http.StatusConflict},
+		{name: "shorten unknown field", operation: "POST /api/shorten", method: http.MethodPost, target: "/api/shorten", contentType: "application/json", body: `{"link":"http://example.com"}`, wantStatus: http.StatusBadRequest},
+		{name: "shorten bad token", operation: "POST /api/shorten", method: http.MethodPost, target: "/api/shorten", contentType: "application/json", body: `{"url":"http://example.com"}`, token: "sk_revoked", wantStatus: http.StatusUnauthorized},
+		{name: "shorten without scope", operation: "POST /api/shorten", method: http.MethodPost, target: "/api/shorten", contentType: "application/json", body: `{"url":"http://example.com"}`, token: "sk_reader", wantStatus: http.StatusForbidden},
+		{name: "batch", operation: "POST /api/shorten/batch", method: http.MethodPost, target: "/api/shorten/batch", contentType: "application/json", body: `[{"correlation_id":"1","original_url":"http://example.com"}]`, wantStatus: http.StatusCreated},
+		{name: "batch exists", operation: "POST /api/shorten/batch", method: http.MethodPost, target: "/api/shorten/batch", contentType: "application/json", body: `[{"correlation_id":"1","original_url":"http://exists.example"}]`, wantStatus: http.StatusConflict},
+		{name: "batch empty", operation: "POST /api/shorten/batch", method: http.MethodPost, target: "/api/shorten/batch", contentType: "application/json", body: `[]`, wantStatus: http.StatusBadRequest},
+
+		{name: "export json", operation: "GET /api/user/urls/export", method: http.MethodGet, target: "/api/user/urls/export", wantStatus: http.StatusOK},
+		{name: "export ndjson", operation: "GET /api/user/urls/export", method: http.MethodGet, target: "/api/user/urls/export?format=ndjson&from=2023-01-01", wantStatus: http.StatusOK},
+		{name: "export csv", operation: "GET /api/user/urls/export", method: http.MethodGet, target: "/api/user/urls/export?format=csv", wantStatus: http.StatusOK},
+		{name: "export bad format", operation: "GET /api/user/urls/export", method: http.MethodGet, target: "/api/user/urls/export?format=xml", wantStatus: http.StatusBadRequest},
+		{name: "export anonymous", operation: "GET /api/user/urls/export", method: http.MethodGet, target: "/api/user/urls/export", anonymous: true, wantStatus: http.StatusUnauthorized},
+		{name: "broken", operation: "GET /api/user/urls/broken", method: http.MethodGet, target: "/api/user/urls/broken", wantStatus: http.StatusOK},
+		{name: "update", operation: "PATCH /api/urls/{id}", method: http.MethodPatch, target: "/api/urls/abc", contentType: "application/json", body: `{"redirect_type":301,"expires_at":"2024-01-01T01:00:00Z"}`, wantStatus: http.StatusOK},
+		{name: "update nothing", operation: "PATCH /api/urls/{id}", method: http.MethodPatch, target: "/api/urls/abc", contentType: "application/json", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "update unknown", operation: "PATCH /api/urls/{id}", method: http.MethodPatch, target: "/api/urls/missing", contentType: "application/json", body: `{"expires_at":null}`, wantStatus: http.StatusNotFound},
+		{name: "update foreign", operation: "PATCH /api/urls/{id}", method: http.MethodPatch, target: "/api/urls/other", contentType: "application/json", body: `{"original_url":"http://example.org"}`, wantStatus: http.StatusForbidden},
+		{name: "history", operation: "GET /api/urls/{id}/history", method: http.MethodGet, target: "/api/urls/abc/history", wantStatus: http.StatusOK},
+
+		{name: "create key", operation: "POST /api/keys", method: http.MethodPost, target: "/api/keys", contentType: "application/json", body: `{"name":"ci","scopes":["shorten","read"]}`, wantStatus: http.StatusCreated},
+		{name: "create key bad scope", operation: "POST /api/keys", method: http.MethodPost, target: "/api/keys", contentType: "application/json", body: `{"scopes":["admin"]}`, wantStatus: http.StatusBadRequest},
+		{name: "list keys", operation: "GET /api/keys", method: http.MethodGet, target: "/api/keys", wantStatus: http.StatusOK},
+		{name: "list keys with key", operation: "GET /api/keys", method: http.MethodGet, target: "/api/keys", token: "sk_reader", wantStatus: http.StatusForbidden},
+		{name: "delete key", operation: "DELETE /api/keys/{id}", method: http.MethodDelete, target: "/api/keys/id-1", wantStatus: http.StatusNoContent},
+		{name: "delete unknown key", operation: "DELETE /api/keys/{id}", method: http.MethodDelete, target: "/api/keys/id-2", wantStatus: http.StatusNotFound},
+
+		{name: "admin search", operation: "GET /api/admin/urls", method: http.MethodGet, target: "/api/admin/urls?q=example&limit=10", wantStatus: http.StatusOK},
+		{name: "admin search bad limit", operation: "GET /api/admin/urls", method: http.MethodGet, target: "/api/admin/urls?limit=0", wantStatus: http.StatusBadRequest},
+		{name: "admin search not admin", operation: "GET /api/admin/urls", method: http.MethodGet, target: "/api/admin/urls", token: "sk_reader", wantStatus: http.StatusForbidden},
+		{name: "admin disable", operation: "POST /api/admin/urls/{id}/disable", method: http.MethodPost, target: "/api/admin/urls/abc/disable", wantStatus: http.StatusNoContent},
+		{name: "admin enable", operation: "POST /api/admin/urls/{id}/enable", method: http.MethodPost, target: "/api/admin/urls/abc/enable", wantStatus: http.StatusNoContent},
+		{name: "admin enable unknown", operation: "POST /api/admin/urls/{id}/enable", method: http.MethodPost, target: "/api/admin/urls/missing/enable", wantStatus: http.StatusNotFound},
+		{name: "admin delete", operation: "DELETE /api/admin/urls/{id}", method: http.MethodDelete, target: "/api/admin/urls/abc", wantStatus: http.StatusNoContent},
+
+		{name: "openapi", operation: "GET /api/openapi.json", method: http.MethodGet, target: "/api/openapi.json", wantStatus: http.StatusOK},
+		{name: "docs", operation: "GET /api/docs", method: http.MethodGet, target: "/api/docs", wantStatus: http.StatusOK},
+		{name: "docs file", operation: "GET /api/docs/{path}", method: http.MethodGet, target: "/api/docs/openapi.json", wantStatus: http.StatusOK},
+		{name: "docs missing file", operation: "GET /api/docs/{path}", method: http.MethodGet, target: "/api/docs/swagger-ui/missing.js", wantStatus: http.StatusNotFound},
+		{name: "docs directory", operation: "GET /api/docs/{path}", method: http.MethodGet, target: "/api/docs/swagger-ui", wantStatus: http.StatusNotFound},
+	}
+
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			switch {
+			case tt.token != "":
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			case !tt.anonymous:
+				req.AddCookie(session)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			require.Equal(t, tt.wantStatus, recorder.Code, recorder.Body.String())
+			method, path, _ := strings.Cut(tt.operation, " ")
+			assert.NoError(t, spec.checkResponse(method, path, recorder))
+		})
+		covered[tt.operation] = true
+	}
+
+	// у каждой операции из описания есть хотя бы один запрос
+	paths, _ := spec["paths"].(map[string]interface{})
+	for path, item := range paths {
+		for method := range item.(map[string]interface{}) {
+			if method != "parameters" {
+				assert.True(t, covered[strings.ToUpper(method)+" "+path], "no request for %s %s", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/condratf/shortner/internal/app/config"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+)
+
+// crawlerAgents — фрагменты User-Agent ботов, которые строят превью ссылок
+var crawlerAgents = []string{
+	"facebookexternalhit",
+	"facebot",
+	"twitterbot",
+	"slackbot",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"linkedinbot",
+	"skypeuripreview",
+	"vkshare",
+	"pinterest",
+	"redditbot",
+	"embedly",
+	"mattermost",
+	"applebot",
+}
+
+func isCrawler(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, agent := range crawlerAgents {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+	return false
+}
+
+type unfurlPage struct {
+	storage.PageMeta
+	ShortURL string
+	// Target — адрес для canonical и ссылки на странице: исходный URL или,
+	// для ограниченных ссылок, сама короткая ссылка
+	Target string
+}
+
+// renderUnfurlPage отдаёт боту страницу с og:*-тегами назначения.
+func renderUnfurlPage(w http.ResponseWriter, r *http.Request, data storage.URLData) {
+	shortURL, err := utils.ConstructURL(config.Config.BaseURL, data.ShortURL)
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err, "could not construct URL"))
+		return
+	}
+
+	page := unfurlPage{
+		ShortURL: shortURL,
+		Target:   data.OriginalURL,
+	}
+	if data.PageMeta != nil {
+		page.PageMeta = *data.PageMeta
+	}
+	if data.Restricted() {
+		page.Target = shortURL
+	}
+	if page.Title == "" {
+		page.Title = page.Target
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	pageTemplates.ExecuteTemplate(w, "unfurl.html", page)
+}
@@ -0,0 +1,160 @@
+package router
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+var pageTemplates = template.Must(template.ParseFS(templatesFS, "templates/*.html"))
+
+const (
+	unlockMaxFailures = 5
+	unlockWindow      = 15 * time.Minute
+	// unlockSweepSize — размер таблицы, после которого из неё удаляются истёкшие записи
+	unlockSweepSize = 1024
+)
+
+type unlockPage struct {
+	Error string
+}
+
+func renderUnlockPage(w http.ResponseWriter, status int, page unlockPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	pageTemplates.ExecuteTemplate(w, "unlock.html", page)
+}
+
+// unlockLimiter считает неудачные попытки ввода пароля для пары ключ+IP
+// и блокирует пару на window после maxFailures ошибок.
+type unlockLimiter struct {
+	mu          sync.Mutex
+	maxFailures int
+	window      time.Duration
+	now         func() time.Time
+	failures    map[string]*unlockFailures
+}
+
+type unlockFailures struct {
+	count   int
+	resetAt time.Time
+}
+
+func newUnlockLimiter(maxFailures int, window time.Duration) *unlockLimiter {
+	return &unlockLimiter{
+		maxFailures: maxFailures,
+		window:      window,
+		now:         time.Now,
+		failures:    make(map[string]*unlockFailures),
+	}
+}
+
+// tryAcquire резервирует попытку ввода пароля для key до его проверки:
+// параллельные запросы не проходят проверку все разом, пока не учтена
+// первая ошибка. Если попытки исчерпаны, возвращает время до следующей.
+// Зарезервированная попытка считается неудачной, пока её не отменят
+// release или reset.
+func (l *unlockLimiter) tryAcquire(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.failures) >= unlockSweepSize {
+		for k, f := range l.failures {
+			if !now.Before(f.resetAt) {
+				delete(l.failures, k)
+			}
+		}
+	}
+
+	f, ok := l.failures[key]
+	if !ok || !now.Before(f.resetAt) {
+		f = &unlockFailures{resetAt: now.Add(l.window)}
+		l.failures[key] = f
+	}
+	if f.count >= l.maxFailures {
+		return f.resetAt.Sub(now), false
+	}
+	f.count++
+	return 0, true
+}
+
+// release отменяет попытку, которая не дошла до сравнения пароля.
+func (l *unlockLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.failures[key]; ok && f.count > 0 {
+		f.count--
+	}
+}
+
+func (l *unlockLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, key)
+}
+
+func unlockHandler(
+	unlockURL func(context.Context, string, string) (storage.URLData, error),
+	limiter *unlockLimiter,
+	notFound http.Handler,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		attemptKey := id + "|" + clientIP(r)
+
+		wait, ok := limiter.tryAcquire(attemptKey)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+			renderUnlockPage(w, http.StatusTooManyRequests, unlockPage{Error: "Too many attempts, try again later."})
+			return
+		}
+
+		data, err := unlockURL(r.Context(), id, r.PostFormValue("password"))
+		if err != nil {
+			// неверный пароль оставляет попытку учтённой
+			if errors.Is(err, utils.ErrWrongPassword) {
+				renderUnlockPage(w, http.StatusUnauthorized, unlockPage{Error: "Wrong password."})
+				return
+			}
+			if errors.Is(err, storage.ErrClickLimitReached) {
+				limiter.reset(attemptKey)
+				problem.Write(w, r, err)
+				return
+			}
+			limiter.release(attemptKey)
+			writeLookupError(w, r, err, notFound)
+			return
+		}
+		limiter.reset(attemptKey)
+
+		if data.Disabled {
+			problem.Write(w, r, problem.ErrLinkDisabled)
+			return
+		}
+		if data.Expired(time.Now()) {
+			problem.Write(w, r, problem.ErrLinkExpired)
+			return
+		}
+
+		// 303 превращает POST формы в GET на исходный адрес
+		w.Header().Set("Location", data.OriginalURL)
+		w.WriteHeader(http.StatusSeeOther)
+	}
+}
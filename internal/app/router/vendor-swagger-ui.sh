@@ -0,0 +1,18 @@
+#!/bin/sh
+# Загружает сборку Swagger UI, которую встраивает страница /api/docs.
+# Запускается через go generate ./internal/app/router; для обновления
+# поменяйте VERSION и закоммитьте содержимое docs/swagger-ui.
+set -eu
+
+VERSION=5.17.14
+DEST=$(dirname "$0")/docs/swagger-ui
+TMP=$(mktemp -d)
+trap 'rm -rf "$TMP"' EXIT
+
+curl -fsSL "https://registry.npmjs.org/swagger-ui-dist/-/swagger-ui-dist-$VERSION.tgz" | tar -xz -C "$TMP"
+
+mkdir -p "$DEST"
+for f in swagger-ui.css swagger-ui-bundle.js swagger-ui-standalone-preset.js LICENSE; do
+	cp "$TMP/package/$f" "$DEST/$f"
+done
+echo "$VERSION" > "$DEST/VERSION"
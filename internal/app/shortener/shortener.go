@@ -1,9 +1,12 @@
 package shortener
 
 import (
+	"context"
 	"crypto/rand"
 	"math/big"
 	"strings"
+
+	"github.com/condratf/shortner/internal/app/tracing"
 )
 
 const (
@@ -12,8 +15,10 @@ const (
 	charsetSize = int64(len(charset))
 )
 
+var tracer = tracing.Tracer("shortener")
+
 type Shortener interface {
-	Shorten(url string) (string, error)
+	Shorten(ctx context.Context, url string) (string, error)
 }
 
 type DefaultShortener struct{}
@@ -22,7 +27,10 @@ func NewShortener() Shortener {
 	return &DefaultShortener{}
 }
 
-func (s *DefaultShortener) Shorten(originalURL string) (string, error) {
+func (s *DefaultShortener) Shorten(ctx context.Context, originalURL string) (_ string, err error) {
+	_, span := tracer.Start(ctx, "Shortener.Shorten")
+	defer func() { tracing.End(span, err) }()
+
 	var builder strings.Builder
 	builder.Grow(urlLength)
 
@@ -1,6 +1,7 @@
 package shortener
 
 import (
+	"context"
 	"strings"
 	"testing"
 	"unicode"
@@ -56,7 +57,7 @@ func TestShortener(t *testing.T) {
 			checkFunc: func(t *testing.T, shortURL string) {
 				urls := make(map[string]bool)
 				for i := 0; i < 100; i++ {
-					shortURL, err := NewShortener().Shorten("https://example.com")
+					shortURL, err := NewShortener().Shorten(context.Background(), "https://example.com")
 					if err != nil {
 						t.Fatalf("unexpected error: %v", err)
 					}
@@ -72,7 +73,7 @@ func TestShortener(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			shortener := NewShortener()
-			shortURL, err := shortener.Shorten(tt.inputURL)
+			shortURL, err := shortener.Shorten(context.Background(), tt.inputURL)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("unexpected error: %v", err)
@@ -1,39 +1,88 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net/http"
+	"os"
+	"time"
 
+	"github.com/condratf/shortner/internal/app/audit"
+	"github.com/condratf/shortner/internal/app/auth"
 	"github.com/condratf/shortner/internal/app/config"
 	"github.com/condratf/shortner/internal/app/db"
+	"github.com/condratf/shortner/internal/app/export"
+	"github.com/condratf/shortner/internal/app/healthcheck"
+	"github.com/condratf/shortner/internal/app/logger"
 	"github.com/condratf/shortner/internal/app/models"
+	"github.com/condratf/shortner/internal/app/router"
 	"github.com/condratf/shortner/internal/app/shortener"
 	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/condratf/shortner/internal/app/tracing"
+	"github.com/condratf/shortner/internal/app/unfurl"
 	"github.com/condratf/shortner/internal/app/utils"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
+var tracer = tracing.Tracer("app")
+
 func shortURLAndStore(
 	short shortener.Shortener,
 	store storage.Storage,
-) func(originalURL string) (string, error) {
-	var inner func(originalURL string) (string, error)
+	unfurler *unfurl.Worker,
+) func(ctx context.Context, originalURL string, opts models.LinkOptions) (string, error) {
+	var inner func(ctx context.Context, originalURL string, opts models.LinkOptions) (string, error)
+
+	inner = func(ctx context.Context, originalURL string, opts models.LinkOptions) (string, error) {
+		if err := utils.ValidateURL(originalURL); err != nil {
+			return "", err
+		}
 
-	inner = func(originalURL string) (string, error) {
-		key, err := short.Shorten(originalURL)
+		key, err := short.Shorten(ctx, originalURL)
 		if err != nil {
 			return "", err
 		}
-		if url, _ := store.Get(key); url != "" {
-			return inner(originalURL)
+		if existing, _ := store.Get(ctx, key); existing.OriginalURL != "" {
+			return inner(ctx, originalURL, opts)
 		}
 
-		_, err = store.Save(key, originalURL)
+		var passwordHash string
+		if opts.Password != "" {
+			passwordHash, err = utils.HashPassword(opts.Password)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		_, err = store.Save(ctx, storage.URLData{
+			ShortURL:       key,
+			OriginalURL:    originalURL,
+			UserID:         auth.UserIDFromContext(ctx),
+			AllowDuplicate: opts.AllowDuplicate,
+			Tag:            opts.Tag,
+			Metadata:       opts.Metadata,
+			PasswordHash:   passwordHash,
+			MaxClicks:      opts.MaxClicks,
+		})
 		if errors.Is(err, &storage.ErrURLExists{}) {
-			fmt.Println("URL already exists")
+			logger.FromContext(ctx).Debug("URL already exists", zap.String("original_url", originalURL))
+			return "", err
+		}
+		if err != nil {
 			return "", err
 		}
-		store.SaveToFile(config.Config.FilePath)
+		ctx = logger.With(ctx, zap.String("short_key", key))
+		logger.FromContext(ctx).Debug("short URL created")
+		saveToFile(ctx, store)
+		// превью защищённых паролем ссылок не показывается, загружать его незачем
+		if passwordHash == "" {
+			unfurler.Enqueue(key, originalURL)
+		}
 
 		shortURL, err := utils.ConstructURL(config.Config.BaseURL, key)
 		if err != nil {
@@ -43,38 +92,271 @@ func shortURLAndStore(
 		return shortURL, nil
 	}
 
-	return inner
+	return func(ctx context.Context, originalURL string, opts models.LinkOptions) (shortURL string, err error) {
+		ctx, span := tracer.Start(ctx, "shortURLAndStore")
+		defer func() { tracing.End(span, err) }()
+		return inner(ctx, originalURL, opts)
+	}
 }
 
-func getURL(store storage.Storage) func(key string) (string, error) {
-	return func(key string) (string, error) {
-		url, err := store.Get(key)
+func getURL(store storage.Storage) func(ctx context.Context, key string) (storage.URLData, error) {
+	return func(ctx context.Context, key string) (_ storage.URLData, err error) {
+		ctx, span := tracer.Start(ctx, "getURL", trace.WithAttributes(attribute.String("short_key", key)))
+		defer func() { tracing.End(span, err) }()
 
+		data, err := store.Get(ctx, key)
 		if err != nil {
-			return "", err
+			return storage.URLData{}, err
+		}
+		return data, nil
+	}
+}
+
+func unlockURL(store storage.Storage) func(ctx context.Context, key, password string) (storage.URLData, error) {
+	return func(ctx context.Context, key, password string) (storage.URLData, error) {
+		data, err := store.Get(ctx, key)
+		if err != nil {
+			return storage.URLData{}, err
+		}
+		if data.PasswordHash != "" {
+			if err := utils.CheckPassword(data.PasswordHash, password); err != nil {
+				return storage.URLData{}, err
+			}
+		}
+		// отключённая или истёкшая ссылка не открывается, и переход не учитывается
+		if data.Disabled || data.Expired(time.Now()) {
+			return data, nil
+		}
+		return clickURL(store)(ctx, key)
+	}
+}
+
+func clickURL(store storage.Storage) func(ctx context.Context, key string) (storage.URLData, error) {
+	return func(ctx context.Context, key string) (storage.URLData, error) {
+		data, err := store.Click(ctx, key)
+		if err != nil {
+			return storage.URLData{}, err
+		}
+		// счётчик ограниченных ссылок должен пережить перезапуск
+		if data.MaxClicks > 0 {
+			saveToFile(ctx, store)
+		}
+		return data, nil
+	}
+}
+
+func updateURL(store storage.Storage, unfurler *unfurl.Worker) func(ctx context.Context, key string, upd storage.URLUpdate) (storage.URLData, error) {
+	return func(ctx context.Context, key string, upd storage.URLUpdate) (storage.URLData, error) {
+		if upd.OriginalURL != nil {
+			if err := utils.ValidateURL(*upd.OriginalURL); err != nil {
+				return storage.URLData{}, err
+			}
+		}
+
+		data, err := store.Update(ctx, key, auth.UserIDFromContext(ctx), upd)
+		if err != nil {
+			return storage.URLData{}, err
+		}
+		saveToFile(ctx, store)
+		// Update сбросил превью прежнего адреса, загружаем превью нового
+		if upd.OriginalURL != nil && data.PageMeta == nil && data.PasswordHash == "" {
+			unfurler.Enqueue(key, data.OriginalURL)
+		}
+
+		return data, nil
+	}
+}
+
+func urlHistory(store storage.Storage) func(ctx context.Context, key string) ([]storage.HistoryEntry, error) {
+	return func(ctx context.Context, key string) ([]storage.HistoryEntry, error) {
+		data, err := store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if data.UserID == "" || data.UserID != auth.UserIDFromContext(ctx) {
+			return nil, storage.ErrForbidden
+		}
+
+		return store.History(ctx, key)
+	}
+}
+
+func createAPIKey(store storage.Storage) func(ctx context.Context, name string, scopes []string) (storage.APIKey, string, error) {
+	return func(ctx context.Context, name string, scopes []string) (storage.APIKey, string, error) {
+		token, prefix, hash, err := auth.GenerateAPIKey()
+		if err != nil {
+			return storage.APIKey{}, "", err
+		}
+
+		key := storage.APIKey{
+			ID:        uuid.New().String(),
+			UserID:    auth.UserIDFromContext(ctx),
+			Name:      name,
+			Prefix:    prefix,
+			Hash:      hash,
+			Scopes:    scopes,
+			CreatedAt: time.Now().UTC(),
+		}
+		if err := store.SaveAPIKey(ctx, key); err != nil {
+			return storage.APIKey{}, "", err
+		}
+		// ключ, который не переживёт перезапуск, лучше не выдавать вовсе
+		if err := store.SaveToFile(config.Config.FilePath); err != nil {
+			if delErr := store.DeleteAPIKey(ctx, key.ID, key.UserID); delErr != nil {
+				logger.FromContext(ctx).Error("failed to revoke unsaved API key", zap.Error(delErr))
+			}
+			return storage.APIKey{}, "", err
+		}
+
+		return key, token, nil
+	}
+}
+
+func listAPIKeys(store storage.Storage) func(ctx context.Context) ([]storage.APIKey, error) {
+	return func(ctx context.Context) ([]storage.APIKey, error) {
+		return store.ListAPIKeys(ctx, auth.UserIDFromContext(ctx))
+	}
+}
+
+func deleteAPIKey(store storage.Storage) func(ctx context.Context, id string) error {
+	return func(ctx context.Context, id string) error {
+		if err := store.DeleteAPIKey(ctx, id, auth.UserIDFromContext(ctx)); err != nil {
+			return err
+		}
+		return store.SaveToFile(config.Config.FilePath)
+	}
+}
+
+// lookupAPIKey находит владельца ключа доступа для auth.Middleware.
+func lookupAPIKey(store storage.Storage) auth.TokenResolver {
+	return func(ctx context.Context, token string) (auth.Identity, error) {
+		key, err := store.GetAPIKey(ctx, auth.HashAPIKey(token))
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return auth.Identity{}, auth.ErrInvalidAPIKey
+		}
+		if err != nil {
+			return auth.Identity{}, err
+		}
+
+		return auth.Identity{UserID: key.UserID, APIKeyID: key.ID, Scopes: key.Scopes}, nil
+	}
+}
+
+func adminSearch(store storage.Storage, auditLog *audit.Log) func(ctx context.Context, filter storage.ListFilter) ([]storage.URLData, error) {
+	return func(ctx context.Context, filter storage.ListFilter) ([]storage.URLData, error) {
+		page, err := store.List(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		details := map[string]string{}
+		for name, value := range map[string]string{
+			"q":       filter.OriginalContains,
+			"user_id": filter.UserID,
+			"after":   filter.After,
+		} {
+			if value != "" {
+				details[name] = value
+			}
+		}
+		if !filter.From.IsZero() {
+			details["from"] = filter.From.Format(time.RFC3339)
+		}
+		if !filter.To.IsZero() {
+			details["to"] = filter.To.Format(time.RFC3339)
+		}
+		err = auditLog.Record(audit.Entry{
+			Actor:   auth.UserIDFromContext(ctx),
+			Action:  audit.ActionSearch,
+			Details: details,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return page, nil
+	}
+}
+
+// adminSetDisabled и adminDelete пишут журнал аудита до изменения ссылки:
+// действие, которое не удалось записать в журнал, не выполняется, а изменение
+// не превращается в ошибку из-за сбоя журнала после него.
+func adminSetDisabled(store storage.Storage, auditLog *audit.Log) func(ctx context.Context, key string, disabled bool) error {
+	return func(ctx context.Context, key string, disabled bool) error {
+		if _, err := store.Get(ctx, key); err != nil {
+			return err
+		}
+
+		action := audit.ActionEnable
+		if disabled {
+			action = audit.ActionDisable
+		}
+		err := auditLog.Record(audit.Entry{
+			Actor:    auth.UserIDFromContext(ctx),
+			Action:   action,
+			ShortURL: key,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := store.SetDisabled(ctx, key, disabled); err != nil {
+			return err
+		}
+		saveToFile(ctx, store)
+		return nil
+	}
+}
+
+func adminDelete(store storage.Storage, auditLog *audit.Log) func(ctx context.Context, key string) error {
+	return func(ctx context.Context, key string) error {
+		data, err := store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		err = auditLog.Record(audit.Entry{
+			Actor:    auth.UserIDFromContext(ctx),
+			Action:   audit.ActionDelete,
+			ShortURL: key,
+			Details:  map[string]string{"original_url": data.OriginalURL, "user_id": data.UserID},
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := store.Delete(ctx, key); err != nil {
+			return err
 		}
-		return url, nil
+		saveToFile(ctx, store)
+		return nil
 	}
 }
 
 func shortURLAndStoreBatch(
 	short shortener.Shortener,
 	store storage.Storage,
-) func(origURLs []models.RequestPayloadBatch) ([]models.BatchItem, error) {
-	return func(origURLs []models.RequestPayloadBatch) ([]models.BatchItem, error) {
-		var batchData []models.BatchItem
+	unfurler *unfurl.Worker,
+) func(ctx context.Context, origURLs []models.RequestPayloadBatch) ([]models.BatchItem, error) {
+	return func(ctx context.Context, origURLs []models.RequestPayloadBatch) ([]models.BatchItem, error) {
+		userID := auth.UserIDFromContext(ctx)
+		var batchData []storage.URLData
 		var batchDataResponse []models.BatchItem
 
 		for _, orig := range origURLs {
-			key, err := short.Shorten(orig.OriginalURL)
+			if err := utils.ValidateURL(orig.OriginalURL); err != nil {
+				return nil, fmt.Errorf("%w: %s", err, orig.OriginalURL)
+			}
+			key, err := short.Shorten(ctx, orig.OriginalURL)
 			if err != nil {
 				return nil, fmt.Errorf("failed to shorten URL %s: %w", orig.OriginalURL, err)
 			}
 
-			batchData = append(batchData, models.BatchItem{
-				CorrelationID: orig.CorrelationID,
-				ShortURL:      key,
-				OriginalURL:   orig.OriginalURL,
+			batchData = append(batchData, storage.URLData{
+				UUID:        orig.CorrelationID,
+				ShortURL:    key,
+				OriginalURL: orig.OriginalURL,
+				UserID:      userID,
 			})
 
 			shortURL, err := utils.ConstructURL(config.Config.BaseURL, key)
@@ -89,23 +371,197 @@ func shortURLAndStoreBatch(
 			})
 		}
 
-		_, err := store.SaveBatch(batchData)
+		_, err := store.SaveBatch(ctx, batchData)
 		if err != nil {
 			if errors.Is(err, &storage.ErrURLExists{}) {
 				return nil, err
 			}
 			return nil, fmt.Errorf("failed to save batch: %w", err)
 		}
+		saveToFile(ctx, store)
+		for _, data := range batchData {
+			unfurler.Enqueue(data.ShortURL, data.OriginalURL)
+		}
 
 		return batchDataResponse, nil
 	}
 }
 
+// brokenURLs возвращает ссылки пользователя, последняя проверка которых
+// завершилась ошибкой.
+func brokenURLs(store storage.Storage) func(ctx context.Context, userID string) ([]storage.URLData, error) {
+	return func(ctx context.Context, userID string) ([]storage.URLData, error) {
+		broken := []storage.URLData{}
+		err := storage.Iterate(ctx, store, storage.ListFilter{UserID: userID}, func(data storage.URLData) error {
+			if data.Health != nil && data.Health.Broken() {
+				broken = append(broken, data)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return broken, nil
+	}
+}
+
+func exportURLs(store storage.Storage) func(ctx context.Context, w io.Writer, format export.Format, filter storage.ListFilter) error {
+	return func(ctx context.Context, w io.Writer, format export.Format, filter storage.ListFilter) error {
+		return export.Write(ctx, w, format, store, filter)
+	}
+}
+
+// saveToFile сохраняет хранилище в файл. Изменение уже сделано в памяти,
+// поэтому ошибка записи только логируется и не меняет ответ клиенту.
+func saveToFile(ctx context.Context, store storage.Storage) {
+	if err := store.SaveToFile(config.Config.FilePath); err != nil {
+		logger.FromContext(ctx).Error("failed to save storage to file", zap.Error(err))
+	}
+}
+
+// fileSaveDelay — сколько фоновые обработчики копят изменения перед записью файла
+const fileSaveDelay = time.Second
+
+// startFileSaver запускает отложенное сохранение хранилища в файл и возвращает
+// функцию, которая его запрашивает: все запросы за delay дают одну запись файла,
+// а не перезапись всего файла после каждой ссылки. Запрошенная запись
+// выполняется и после завершения ctx.
+func startFileSaver(ctx context.Context, store storage.Storage, delay time.Duration) func() {
+	pending := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-pending:
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			saveToFile(ctx, store)
+		}
+	}()
+
+	return func() {
+		select {
+		case pending <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// initUnfurler запускает фоновую загрузку og:*-тегов, если она включена в конфигурации.
+func initUnfurler(ctx context.Context, store storage.Storage, scheduleSave func()) *unfurl.Worker {
+	if config.Config.UnfurlWorkers <= 0 {
+		return nil
+	}
+
+	save := func(ctx context.Context, shortURL string, meta storage.PageMeta) error {
+		if err := store.SetPageMeta(ctx, shortURL, meta); err != nil {
+			return err
+		}
+		scheduleSave()
+		return nil
+	}
+	worker := unfurl.NewWorker(unfurl.NewFetcher(unfurl.DefaultTimeout, unfurl.DefaultMaxBytes), save, config.Config.UnfurlWorkers)
+	worker.Start(ctx)
+	return worker
+}
+
+// initHealthChecker запускает периодическую проверку доступности ссылок,
+// если она включена в конфигурации.
+func initHealthChecker(ctx context.Context, store storage.Storage) {
+	if config.Config.HealthCheckInterval <= 0 {
+		return
+	}
+
+	checker := healthcheck.NewChecker(unfurl.NewClient(healthcheck.DefaultTimeout), store, store.SetHealth, healthcheck.Options{
+		Interval:  config.Config.HealthCheckInterval,
+		Rate:      config.Config.HealthCheckRate,
+		Workers:   healthcheck.DefaultWorkers,
+		PerHost:   config.Config.HealthCheckPerHost,
+		HostDelay: healthcheck.DefaultHostDelay,
+		// результаты обхода сохраняются в файл разом
+		Flush: func(context.Context) error { return store.SaveToFile(config.Config.FilePath) },
+	})
+	go checker.Run(ctx)
+}
+
+// initLogger создаёт общий логгер по конфигурации и делает его глобальным:
+// код вне HTTP-запросов получает его через logger.FromContext.
+func initLogger() (*zap.Logger, error) {
+	l, err := logger.New(logger.Options{
+		Level:    config.Config.LogLevel,
+		Format:   config.Config.LogFormat,
+		Sampling: config.Config.LogSampling,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	zap.ReplaceGlobals(l)
+	return l, nil
+}
+
+// initTracing настраивает экспорт трассировок по конфигурации.
+func initTracing() (func(context.Context) error, error) {
+	shutdown, err := tracing.Init(context.Background(), tracing.Options{
+		Exporter:    config.Config.TraceExporter,
+		Endpoint:    config.Config.TraceEndpoint,
+		FilePath:    config.Config.TraceFile,
+		SampleRatio: config.Config.TraceSampleRatio,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	return shutdown, nil
+}
+
+// initJWTVerifier создаёт проверку JWT для режима аутентификации jwt.
+// В режиме cookie JWT не принимаются.
+func initJWTVerifier() (auth.TokenResolver, error) {
+	switch config.Config.AuthMode {
+	case auth.ModeCookie:
+		return nil, nil
+	case auth.ModeJWT:
+		return auth.NewJWTVerifier(auth.JWTOptions{
+			Secret:   []byte(config.Config.JWTSecret),
+			JWKSFile: config.Config.JWTJWKSFile,
+			Audience: config.Config.JWTAudience,
+			Issuer:   config.Config.JWTIssuer,
+		})
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", config.Config.AuthMode)
+	}
+}
+
+// initNotFoundHandler строит ответ на переход по неизвестному ключу:
+// перенаправление или своя HTML-страница из конфигурации.
+func initNotFoundHandler() (http.Handler, error) {
+	if config.Config.NotFoundRedirect != "" && config.Config.NotFoundPage != "" {
+		return nil, errors.New("not found redirect and not found page are mutually exclusive")
+	}
+	if config.Config.NotFoundRedirect != "" {
+		if err := utils.ValidateURL(config.Config.NotFoundRedirect); err != nil {
+			return nil, fmt.Errorf("invalid not found redirect: %w", err)
+		}
+	}
+
+	var page []byte
+	if config.Config.NotFoundPage != "" {
+		var err error
+		page, err = os.ReadFile(config.Config.NotFoundPage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read not found page: %w", err)
+		}
+	}
+	return router.NotFoundHandler(config.Config.NotFoundRedirect, page), nil
+}
+
 func initStore() (storage.Storage, error) {
 	if config.Config.DatabaseDSN != "" {
 		if err := db.InitDB(); err != nil {
-			log.Printf("Failed to initialize database: %v", err)
-			return nil, err
+			return nil, fmt.Errorf("failed to initialize database: %w", err)
 		}
 
 		// if err := db.ApplyMigrations(config.Config.DatabaseDSN); err != nil {
@@ -115,8 +571,15 @@ func initStore() (storage.Storage, error) {
 
 		store, err := storage.NewPostgresStore(db.DB)
 		if err != nil {
-			log.Fatalf("Failed to initialize PostgreSQL storage: %v", err)
-			return nil, err
+			return nil, fmt.Errorf("failed to initialize PostgreSQL storage: %w", err)
+		}
+		return store, nil
+	}
+
+	if config.Config.KVPath != "" {
+		store, err := storage.NewBoltStore(config.Config.KVPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize key-value storage: %w", err)
 		}
 		return store, nil
 	}
@@ -125,8 +588,7 @@ func initStore() (storage.Storage, error) {
 		fileStore := storage.NewInMemoryStore()
 		err := fileStore.LoadFromFile(config.Config.FilePath)
 		if err != nil {
-			log.Fatalf("Failed to load from file: %v", err)
-			return nil, err
+			return nil, fmt.Errorf("failed to load from file: %w", err)
 		}
 		return fileStore, nil
 	}
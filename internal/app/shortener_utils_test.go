@@ -0,0 +1,152 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/audit"
+	"github.com/condratf/shortner/internal/app/models"
+	"github.com/condratf/shortner/internal/app/problem"
+	"github.com/condratf/shortner/internal/app/router"
+	"github.com/condratf/shortner/internal/app/shortener"
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingSaveStore — хранилище, у которого не работает запись.
+type failingSaveStore struct {
+	storage.Storage
+	err error
+}
+
+func (s failingSaveStore) Save(context.Context, storage.URLData) (storage.UUID, error) {
+	return "", s.err
+}
+
+// failingGetStore — хранилище, у которого не работает чтение.
+type failingGetStore struct {
+	storage.Storage
+	err error
+}
+
+func (s failingGetStore) Get(context.Context, string) (storage.URLData, error) {
+	return storage.URLData{}, s.err
+}
+
+func TestShortURLAndStore_SaveError(t *testing.T) {
+	store := failingSaveStore{Storage: storage.NewInMemoryStore(), err: errors.New("connection refused")}
+	create := shortURLAndStore(shortener.NewShortener(), store, nil)
+
+	shortURL, err := create(context.Background(), "http://example.com", models.LinkOptions{})
+	assert.ErrorIs(t, err, store.err)
+	assert.Empty(t, shortURL)
+
+	h := router.ShortenerRouter(router.Handlers{ShortURLAndStore: create})
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"http://example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, problem.ContentType, rec.Header().Get("Content-Type"))
+	var p problem.Problem
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, problem.CodeInternal, p.Code)
+	assert.Equal(t, "could not store URL", p.Detail)
+	assert.NotContains(t, rec.Body.String(), "connection refused")
+}
+
+func TestLookupErrors(t *testing.T) {
+	ctx := context.Background()
+
+	// Case: Unknown key stays a not found error
+	store := storage.NewInMemoryStore()
+	_, err := urlHistory(store)(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.ErrorIs(t, adminDelete(store, nil)(ctx, "missing"), storage.ErrNotFound)
+
+	// Case: Storage failure is not reported as an unknown key
+	failing := failingGetStore{Storage: store, err: errors.New("connection refused")}
+	_, err = urlHistory(failing)(ctx, "key1")
+	assert.ErrorIs(t, err, failing.err)
+	assert.NotErrorIs(t, err, storage.ErrNotFound)
+	err = adminDelete(failing, nil)(ctx, "key1")
+	assert.ErrorIs(t, err, failing.err)
+	assert.NotErrorIs(t, err, storage.ErrNotFound)
+}
+
+// failingWriter — журнал аудита, в который нельзя записать.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAdminActionsAreAuditedFirst(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewInMemoryStore()
+	_, err := store.Save(ctx, storage.URLData{ShortURL: "key1", OriginalURL: "http://example.com"})
+	require.NoError(t, err)
+
+	// Case: Action that cannot be audited is not performed
+	broken := audit.NewLog(failingWriter{})
+	assert.Error(t, adminSetDisabled(store, broken)(ctx, "key1", true))
+	assert.Error(t, adminDelete(store, broken)(ctx, "key1"))
+	data, err := store.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.False(t, data.Disabled)
+
+	// Case: Audited action is performed
+	var buf bytes.Buffer
+	auditLog := audit.NewLog(&buf)
+	assert.NoError(t, adminSetDisabled(store, auditLog)(ctx, "key1", true))
+	assert.NoError(t, adminDelete(store, auditLog)(ctx, "key1"))
+	_, err = store.Get(ctx, "key1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+
+	// Case: Unknown key leaves no audit entry
+	assert.ErrorIs(t, adminSetDisabled(store, auditLog)(ctx, "key1", false), storage.ErrNotFound)
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+}
+
+// countingSaveStore считает записи хранилища в файл.
+type countingSaveStore struct {
+	storage.Storage
+	saves atomic.Int32
+}
+
+func (s *countingSaveStore) SaveToFile(string) error {
+	s.saves.Add(1)
+	return nil
+}
+
+func TestStartFileSaver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := &countingSaveStore{Storage: storage.NewInMemoryStore()}
+	scheduleSave := startFileSaver(ctx, store, 50*time.Millisecond)
+
+	// Case: Requests within the delay are merged into one write
+	for i := 0; i < 100; i++ {
+		scheduleSave()
+	}
+	assert.Eventually(t, func() bool { return store.saves.Load() == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.EqualValues(t, 1, store.saves.Load())
+
+	// Case: Pending write is not lost on shutdown
+	scheduleSave()
+	cancel()
+	assert.Eventually(t, func() bool { return store.saves.Load() == 2 }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,508 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+const boltOpenTimeout = time.Second
+
+var (
+	// urlsBucket хранит short_url -> URLData в JSON
+	urlsBucket = []byte("urls")
+	// originalsBucket — обратный индекс original_url -> short_url
+	originalsBucket = []byte("originals")
+	// historyBucket хранит прежние состояния ссылок с ключом short_url\x00seq
+	historyBucket = []byte("history")
+	// apiKeysBucket хранит хеш ключа доступа -> APIKey в JSON
+	apiKeysBucket = []byte("api_keys")
+)
+
+// BoltStore — хранилище во встроенной базе bbolt (B+-дерево в одном файле).
+// Каждая запись сохраняется отдельной транзакцией с fsync, поэтому
+// данные не теряются при падении и файл не переписывается целиком.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (Storage, error) {
+	db, err := bolt.Open(path, FilePermUserReadWrite, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("could not open bolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{urlsBucket, originalsBucket, historyBucket, apiKeysBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) Save(ctx context.Context, data URLData) (string, error) {
+	id := data.UUID
+	if id == "" {
+		id = uuid.New().String()
+	}
+	data.UUID = id
+	if data.CreatedAt.IsZero() {
+		data.CreatedAt = time.Now().UTC()
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if existing := tx.Bucket(originalsBucket).Get([]byte(data.OriginalURL)); existing != nil && !data.AllowDuplicate {
+			return &ErrURLExists{ExistingShortURL: string(existing), ID: id}
+		}
+		return putURL(tx, data)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s *BoltStore) SaveBatch(ctx context.Context, items []URLData) ([]URLData, error) {
+	urlDataList := make([]URLData, 0, len(items))
+	now := time.Now().UTC()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		originals := tx.Bucket(originalsBucket)
+		// исходные URL, добавленные этой же пачкой
+		inBatch := make(map[string]string, len(items))
+		conflictErr := &ErrURLExists{}
+
+		for _, urlData := range items {
+			if !urlData.AllowDuplicate {
+				existing, ok := inBatch[urlData.OriginalURL]
+				if !ok {
+					if v := originals.Get([]byte(urlData.OriginalURL)); v != nil {
+						existing, ok = string(v), true
+					}
+				}
+				if ok {
+					conflictErr.Conflicts = append(conflictErr.Conflicts, URLData{
+						UUID:        urlData.UUID,
+						ShortURL:    existing,
+						OriginalURL: urlData.OriginalURL,
+					})
+					continue
+				}
+				inBatch[urlData.OriginalURL] = urlData.ShortURL
+			}
+
+			if urlData.CreatedAt.IsZero() {
+				urlData.CreatedAt = now
+			}
+			if err := putURL(tx, urlData); err != nil {
+				return err
+			}
+			urlDataList = append(urlDataList, urlData)
+		}
+
+		// как и в PostgresStore, пачка с конфликтом не сохраняется целиком
+		if len(conflictErr.Conflicts) > 0 {
+			conflictErr.ID = conflictErr.Conflicts[0].UUID
+			conflictErr.ExistingShortURL = conflictErr.Conflicts[0].ShortURL
+			return conflictErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return urlDataList, nil
+}
+
+func putURL(tx *bolt.Tx, data URLData) error {
+	urls := tx.Bucket(urlsBucket)
+	if urls.Get([]byte(data.ShortURL)) != nil {
+		return fmt.Errorf("%w: %s", ErrShortURLTaken, data.ShortURL)
+	}
+
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := urls.Put([]byte(data.ShortURL), value); err != nil {
+		return err
+	}
+	if data.AllowDuplicate {
+		return nil
+	}
+	return tx.Bucket(originalsBucket).Put([]byte(data.OriginalURL), []byte(data.ShortURL))
+}
+
+func (s *BoltStore) Get(ctx context.Context, shortURL string) (URLData, error) {
+	var data URLData
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(urlsBucket).Get([]byte(shortURL))
+		if value == nil {
+			return ErrNotFound
+		}
+
+		if err := json.Unmarshal(value, &data); err != nil {
+			return fmt.Errorf("could not decode url: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return URLData{}, err
+	}
+
+	return data, nil
+}
+
+func (s *BoltStore) Update(ctx context.Context, shortURL, userID string, upd URLUpdate) (URLData, error) {
+	var updated URLData
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		value := urls.Get([]byte(shortURL))
+		if value == nil {
+			return ErrNotFound
+		}
+
+		var old URLData
+		if err := json.Unmarshal(value, &old); err != nil {
+			return fmt.Errorf("could not decode url: %w", err)
+		}
+		if old.UserID == "" || old.UserID != userID {
+			return ErrForbidden
+		}
+
+		updated = upd.apply(old)
+		originals := tx.Bucket(originalsBucket)
+		if updated.OriginalURL != old.OriginalURL {
+			if !updated.AllowDuplicate {
+				if existing := originals.Get([]byte(updated.OriginalURL)); existing != nil {
+					return &ErrURLExists{ExistingShortURL: string(existing), ID: old.UUID}
+				}
+			}
+			if bytes.Equal(originals.Get([]byte(old.OriginalURL)), []byte(shortURL)) {
+				if err := originals.Delete([]byte(old.OriginalURL)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := putHistory(tx, newHistoryEntry(old, userID)); err != nil {
+			return err
+		}
+		if err := urls.Delete([]byte(shortURL)); err != nil {
+			return err
+		}
+		return putURL(tx, updated)
+	})
+	if err != nil {
+		return URLData{}, err
+	}
+
+	return updated, nil
+}
+
+func (s *BoltStore) Click(ctx context.Context, shortURL string) (URLData, error) {
+	var data URLData
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		value := urls.Get([]byte(shortURL))
+		if value == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(value, &data); err != nil {
+			return fmt.Errorf("could not decode url: %w", err)
+		}
+		if data.MaxClicks > 0 && data.Clicks >= int64(data.MaxClicks) {
+			return ErrClickLimitReached
+		}
+		data.Clicks++
+
+		value, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return urls.Put([]byte(shortURL), value)
+	})
+	if err != nil {
+		return URLData{}, err
+	}
+
+	return data, nil
+}
+
+func (s *BoltStore) SetPageMeta(ctx context.Context, shortURL string, meta PageMeta) error {
+	return s.modifyURL(shortURL, func(data *URLData) {
+		data.PageMeta = &meta
+	})
+}
+
+func (s *BoltStore) SetHealth(ctx context.Context, shortURL string, health LinkHealth) error {
+	return s.modifyURL(shortURL, func(data *URLData) {
+		data.Health = &health
+	})
+}
+
+func (s *BoltStore) SetDisabled(ctx context.Context, shortURL string, disabled bool) error {
+	return s.modifyURL(shortURL, func(data *URLData) {
+		data.Disabled = disabled
+	})
+}
+
+func (s *BoltStore) Delete(ctx context.Context, shortURL string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		value := urls.Get([]byte(shortURL))
+		if value == nil {
+			return ErrNotFound
+		}
+
+		var data URLData
+		if err := json.Unmarshal(value, &data); err != nil {
+			return fmt.Errorf("could not decode url: %w", err)
+		}
+		if err := urls.Delete([]byte(shortURL)); err != nil {
+			return err
+		}
+
+		originals := tx.Bucket(originalsBucket)
+		if bytes.Equal(originals.Get([]byte(data.OriginalURL)), []byte(shortURL)) {
+			if err := originals.Delete([]byte(data.OriginalURL)); err != nil {
+				return err
+			}
+		}
+
+		return deleteHistory(tx, shortURL)
+	})
+}
+
+func deleteHistory(tx *bolt.Tx, shortURL string) error {
+	// после Delete курсор может пропустить запись на Next, поэтому префикс ищется заново
+	prefix := historyPrefix(shortURL)
+	c := tx.Bucket(historyBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modifyURL перезаписывает запись ссылки, изменённую fn, в одной транзакции.
+func (s *BoltStore) modifyURL(shortURL string, fn func(data *URLData)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		value := urls.Get([]byte(shortURL))
+		if value == nil {
+			return ErrNotFound
+		}
+
+		var data URLData
+		if err := json.Unmarshal(value, &data); err != nil {
+			return fmt.Errorf("could not decode url: %w", err)
+		}
+		fn(&data)
+
+		value, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return urls.Put([]byte(shortURL), value)
+	})
+}
+
+func putHistory(tx *bolt.Tx, entry HistoryEntry) error {
+	history := tx.Bucket(historyBucket)
+	seq, err := history.NextSequence()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := binary.BigEndian.AppendUint64(historyPrefix(entry.ShortURL), seq)
+	return history.Put(key, value)
+}
+
+func historyPrefix(shortURL string) []byte {
+	return append([]byte(shortURL), 0)
+}
+
+func (s *BoltStore) History(ctx context.Context, shortURL string) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := historyPrefix(shortURL)
+		c := tx.Bucket(historyBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var entry HistoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("could not decode history entry: %w", err)
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *BoltStore) SetHistory(ctx context.Context, shortURL string, entries []HistoryEntry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteHistory(tx, shortURL); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			entry.ShortURL = shortURL
+			if err := putHistory(tx, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) SaveAPIKey(ctx context.Context, key APIKey) error {
+	value, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).Put([]byte(key.Hash), value)
+	})
+}
+
+func (s *BoltStore) GetAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	var key APIKey
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(apiKeysBucket).Get([]byte(hash))
+		if value == nil {
+			return ErrAPIKeyNotFound
+		}
+		if err := json.Unmarshal(value, &key); err != nil {
+			return fmt.Errorf("could not decode api key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+func (s *BoltStore) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	return s.listAPIKeys(func(key APIKey) bool { return key.UserID == userID })
+}
+
+func (s *BoltStore) AllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	return s.listAPIKeys(func(APIKey) bool { return true })
+}
+
+func (s *BoltStore) listAPIKeys(match func(APIKey) bool) ([]APIKey, error) {
+	var keys []APIKey
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).ForEach(func(_, v []byte) error {
+			var key APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return fmt.Errorf("could not decode api key: %w", err)
+			}
+			if match(key) {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+func (s *BoltStore) DeleteAPIKey(ctx context.Context, id, userID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(apiKeysBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var key APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return fmt.Errorf("could not decode api key: %w", err)
+			}
+			if key.ID == id && key.UserID == userID {
+				return c.Delete()
+			}
+		}
+		return ErrAPIKeyNotFound
+	})
+}
+
+func (s *BoltStore) List(ctx context.Context, filter ListFilter) ([]URLData, error) {
+	var page []URLData
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(urlsBucket).Cursor()
+
+		k, v := c.Seek([]byte(filter.After))
+		if k != nil && bytes.Equal(k, []byte(filter.After)) {
+			k, v = c.Next()
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			var data URLData
+			if err := json.Unmarshal(v, &data); err != nil {
+				return fmt.Errorf("could not decode url %s: %w", k, err)
+			}
+			if !filter.match(data) {
+				continue
+			}
+			page = append(page, data)
+			if filter.Limit > 0 && len(page) == filter.Limit {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+func (s *BoltStore) LoadFromFile(_ string) error {
+	// данные и так хранятся на диске
+	return nil
+}
+
+func (s *BoltStore) SaveToFile(_ string) error {
+	// данные и так хранятся на диске
+	return nil
+}
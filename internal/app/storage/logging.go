@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/logger"
+	"go.uber.org/zap"
+)
+
+// loggingStore пишет каждую операцию хранилища в лог из контекста,
+// поэтому записи получают идентификатор запроса и ключ ссылки.
+type loggingStore struct {
+	Storage
+}
+
+// WithLogging оборачивает s записью операций в лог на уровне debug.
+func WithLogging(s Storage) Storage {
+	return loggingStore{Storage: s}
+}
+
+func (s loggingStore) log(ctx context.Context, op string, start time.Time, err error, fields ...zap.Field) {
+	l := logger.FromContext(ctx)
+	if !l.Core().Enabled(zap.DebugLevel) {
+		return
+	}
+	fields = append(fields, zap.String("op", op), zap.Duration("duration", time.Since(start)))
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	l.Debug("storage operation", fields...)
+}
+
+func (s loggingStore) Save(ctx context.Context, data URLData) (UUID, error) {
+	start := time.Now()
+	id, err := s.Storage.Save(ctx, data)
+	s.log(ctx, "save", start, err, zap.String("short_key", data.ShortURL))
+	return id, err
+}
+
+func (s loggingStore) SaveBatch(ctx context.Context, items []URLData) ([]URLData, error) {
+	start := time.Now()
+	saved, err := s.Storage.SaveBatch(ctx, items)
+	s.log(ctx, "save_batch", start, err, zap.Int("items", len(items)))
+	return saved, err
+}
+
+func (s loggingStore) Get(ctx context.Context, shortURL string) (URLData, error) {
+	start := time.Now()
+	data, err := s.Storage.Get(ctx, shortURL)
+	s.log(ctx, "get", start, err, zap.String("short_key", shortURL))
+	return data, err
+}
+
+func (s loggingStore) List(ctx context.Context, filter ListFilter) ([]URLData, error) {
+	start := time.Now()
+	page, err := s.Storage.List(ctx, filter)
+	s.log(ctx, "list", start, err, zap.Int("items", len(page)))
+	return page, err
+}
+
+func (s loggingStore) Update(ctx context.Context, shortURL, userID string, upd URLUpdate) (URLData, error) {
+	start := time.Now()
+	data, err := s.Storage.Update(ctx, shortURL, userID, upd)
+	s.log(ctx, "update", start, err, zap.String("short_key", shortURL))
+	return data, err
+}
+
+func (s loggingStore) History(ctx context.Context, shortURL string) ([]HistoryEntry, error) {
+	start := time.Now()
+	entries, err := s.Storage.History(ctx, shortURL)
+	s.log(ctx, "history", start, err, zap.String("short_key", shortURL))
+	return entries, err
+}
+
+func (s loggingStore) SetHistory(ctx context.Context, shortURL string, entries []HistoryEntry) error {
+	start := time.Now()
+	err := s.Storage.SetHistory(ctx, shortURL, entries)
+	s.log(ctx, "set_history", start, err, zap.String("short_key", shortURL), zap.Int("items", len(entries)))
+	return err
+}
+
+func (s loggingStore) Click(ctx context.Context, shortURL string) (URLData, error) {
+	start := time.Now()
+	data, err := s.Storage.Click(ctx, shortURL)
+	s.log(ctx, "click", start, err, zap.String("short_key", shortURL))
+	return data, err
+}
+
+func (s loggingStore) SetPageMeta(ctx context.Context, shortURL string, meta PageMeta) error {
+	start := time.Now()
+	err := s.Storage.SetPageMeta(ctx, shortURL, meta)
+	s.log(ctx, "set_page_meta", start, err, zap.String("short_key", shortURL))
+	return err
+}
+
+func (s loggingStore) SetHealth(ctx context.Context, shortURL string, health LinkHealth) error {
+	start := time.Now()
+	err := s.Storage.SetHealth(ctx, shortURL, health)
+	s.log(ctx, "set_health", start, err, zap.String("short_key", shortURL))
+	return err
+}
+
+func (s loggingStore) SetDisabled(ctx context.Context, shortURL string, disabled bool) error {
+	start := time.Now()
+	err := s.Storage.SetDisabled(ctx, shortURL, disabled)
+	s.log(ctx, "set_disabled", start, err, zap.String("short_key", shortURL))
+	return err
+}
+
+func (s loggingStore) Delete(ctx context.Context, shortURL string) error {
+	start := time.Now()
+	err := s.Storage.Delete(ctx, shortURL)
+	s.log(ctx, "delete", start, err, zap.String("short_key", shortURL))
+	return err
+}
+
+func (s loggingStore) SaveAPIKey(ctx context.Context, key APIKey) error {
+	start := time.Now()
+	err := s.Storage.SaveAPIKey(ctx, key)
+	s.log(ctx, "save_api_key", start, err, zap.String("api_key_id", key.ID))
+	return err
+}
+
+func (s loggingStore) GetAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	start := time.Now()
+	key, err := s.Storage.GetAPIKey(ctx, hash)
+	s.log(ctx, "get_api_key", start, err)
+	return key, err
+}
+
+func (s loggingStore) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	start := time.Now()
+	keys, err := s.Storage.ListAPIKeys(ctx, userID)
+	s.log(ctx, "list_api_keys", start, err, zap.Int("items", len(keys)))
+	return keys, err
+}
+
+func (s loggingStore) AllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	start := time.Now()
+	keys, err := s.Storage.AllAPIKeys(ctx)
+	s.log(ctx, "all_api_keys", start, err, zap.Int("items", len(keys)))
+	return keys, err
+}
+
+func (s loggingStore) DeleteAPIKey(ctx context.Context, id, userID string) error {
+	start := time.Now()
+	err := s.Storage.DeleteAPIKey(ctx, id, userID)
+	s.log(ctx, "delete_api_key", start, err, zap.String("api_key_id", id))
+	return err
+}
+
+// Close закрывает обёрнутое хранилище, если оно этого требует.
+func (s loggingStore) Close() error {
+	if closer, ok := s.Storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
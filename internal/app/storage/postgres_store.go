@@ -1,15 +1,23 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
-	"github.com/condratf/shortner/internal/app/models"
+	"github.com/condratf/shortner/internal/app/tracing"
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = tracing.Tracer("storage")
+
 type PostgresStore struct {
 	db *sql.DB
 }
@@ -17,98 +25,832 @@ type PostgresStore struct {
 func NewPostgresStore(db *sql.DB) (Storage, error) {
 	store := &PostgresStore{db: db}
 
-	query := `
+	queries := []string{`
 		CREATE TABLE IF NOT EXISTS urls (
 			id UUID PRIMARY KEY,
 			short_url TEXT UNIQUE NOT NULL,
-			original_url TEXT UNIQUE NOT NULL
+			original_url TEXT NOT NULL
+		)
+	`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS user_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS allow_duplicate BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS tag TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS metadata JSONB`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_type INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_clicks INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS clicks BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS page_meta JSONB`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS health JSONB`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT false`,
+		// уникальность original_url действует только для ссылок без allow_duplicate
+		`ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_urls_original_url_unique ON urls(original_url) WHERE NOT allow_duplicate`,
+		`CREATE INDEX IF NOT EXISTS idx_urls_user_id ON urls(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_urls_created_at ON urls(created_at)`,
+		`
+		CREATE TABLE IF NOT EXISTS url_history (
+			id BIGSERIAL PRIMARY KEY,
+			short_url TEXT NOT NULL,
+			original_url TEXT NOT NULL,
+			redirect_type INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMPTZ,
+			changed_by TEXT NOT NULL,
+			changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		)
-	`
-	if _, err := db.Exec(query); err != nil {
-		return nil, fmt.Errorf("failed to create table: %w", err)
+	`,
+		`CREATE INDEX IF NOT EXISTS idx_url_history_short_url ON url_history(short_url)`,
+		`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id UUID PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			prefix TEXT NOT NULL,
+			hash TEXT UNIQUE NOT NULL,
+			scopes TEXT[] NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id)`,
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			return nil, fmt.Errorf("failed to create table: %w", err)
+		}
 	}
 
 	return store, nil
 }
 
-func (s *PostgresStore) Save(shortURL, originalURL string) (string, error) {
-	id := uuid.New().String()
+func (s *PostgresStore) Save(ctx context.Context, data URLData) (_ string, err error) {
+	ctx, span := startSpan(ctx, "Save")
+	defer func() { endSpan(span, err) }()
+
+	id := data.UUID
+	if id == "" {
+		id = uuid.New().String()
+	}
+	if data.CreatedAt.IsZero() {
+		data.CreatedAt = time.Now().UTC()
+	}
+	metadata, err := encodeMetadata(data.Metadata)
+	if err != nil {
+		return "", err
+	}
+	pageMeta, err := encodeJSONB(data.PageMeta)
+	if err != nil {
+		return "", err
+	}
+	health, err := encodeJSONB(data.Health)
+	if err != nil {
+		return "", err
+	}
 	query := `
-    INSERT INTO urls (id, short_url, original_url)
-    VALUES ($1, $2, $3)
-    ON CONFLICT (original_url) DO NOTHING
+    INSERT INTO urls (id, short_url, original_url, user_id, created_at, allow_duplicate, tag, metadata, redirect_type, expires_at, password_hash, max_clicks, clicks, page_meta, health, disabled)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+    ON CONFLICT (original_url) WHERE NOT allow_duplicate DO NOTHING
     RETURNING id, short_url
   `
 
 	var returnedShortURL string
-	err := s.db.QueryRow(query, id, shortURL, originalURL).Scan(&id, &returnedShortURL)
+	err = s.db.QueryRowContext(ctx,
+		query, id, data.ShortURL, data.OriginalURL, data.UserID, data.CreatedAt,
+		data.AllowDuplicate, data.Tag, metadata, data.RedirectType, data.ExpiresAt, data.PasswordHash,
+		data.MaxClicks, data.Clicks, pageMeta, health, data.Disabled,
+	).Scan(&id, &returnedShortURL)
 
-	if err != nil {
-		existingShortURL, fetchErr := s.getShortURLByOriginal(originalURL)
+	// ON CONFLICT DO NOTHING не возвращает строк, если original_url уже сохранён
+	if errors.Is(err, sql.ErrNoRows) {
+		existingShortURL, fetchErr := s.getShortURLByOriginal(ctx, data.OriginalURL)
 		if fetchErr != nil {
 			return "", fmt.Errorf("could not fetch existing short URL: %w", fetchErr)
 		}
 		return "", &ErrURLExists{ExistingShortURL: existingShortURL, ID: id}
 	}
+	if err != nil {
+		return "", fmt.Errorf("could not save url: %w", err)
+	}
 
 	return id, nil
 }
 
-func (s *PostgresStore) SaveBatch(items []models.BatchItem) ([]URLData, error) {
-	var urlDataList []URLData
-	query := `
-    INSERT INTO urls (id, short_url, original_url)
-    VALUES ($1, $2, $3)
-    ON CONFLICT (original_url) DO NOTHING
-    RETURNING id, short_url
-  `
+func (s *PostgresStore) SaveBatch(ctx context.Context, items []URLData) (_ []URLData, err error) {
+	ctx, span := startSpan(ctx, "SaveBatch")
+	defer func() { endSpan(span, err) }()
+
+	if len(items) == 0 {
+		return nil, nil
+	}
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	for _, item := range items {
-		var id string
-		var returnedShortURL string
+	// строки сначала копируются во временную таблицу одним COPY,
+	// а затем переносятся в urls одним INSERT ... SELECT
+	if _, err := tx.ExecContext(ctx, createBatchTableQuery); err != nil {
+		return nil, fmt.Errorf("could not create batch table: %w", err)
+	}
+
+	now := time.Now().UTC()
+	items = append([]URLData(nil), items...)
+	for i := range items {
+		if items[i].CreatedAt.IsZero() {
+			items[i].CreatedAt = now
+		}
+	}
+
+	if err := copyBatch(ctx, tx, items); err != nil {
+		return nil, err
+	}
+
+	inserted, err := insertBatch(ctx, tx)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == shortURLConstraint {
+			return nil, fmt.Errorf("%w: %s", ErrShortURLTaken, pqErr.Detail)
+		}
+		return nil, err
+	}
+
+	if len(inserted) != len(items) {
+		return nil, s.batchConflicts(ctx, tx, items, inserted)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
 
-		err := tx.QueryRow(query, item.CorrelationID, item.ShortURL, item.OriginalURL).Scan(&id, &returnedShortURL)
+	return items, nil
+}
+
+var batchColumns = []string{
+	"id", "short_url", "original_url", "user_id", "created_at",
+	"allow_duplicate", "tag", "metadata", "redirect_type", "expires_at", "password_hash",
+	"max_clicks", "clicks", "page_meta", "health", "disabled", "position",
+}
+
+const (
+	batchTable = "urls_batch"
+
+	// uniqueViolation — код ошибки PostgreSQL при нарушении уникальности,
+	// shortURLConstraint — ограничение UNIQUE (short_url) таблицы urls
+	uniqueViolation    = "23505"
+	shortURLConstraint = "urls_short_url_key"
+
+	createBatchTableQuery = `
+    CREATE TEMP TABLE urls_batch (
+      id UUID,
+      short_url TEXT,
+      original_url TEXT,
+      user_id TEXT,
+      created_at TIMESTAMPTZ,
+      allow_duplicate BOOLEAN,
+      tag TEXT,
+      metadata JSONB,
+      redirect_type INTEGER,
+      expires_at TIMESTAMPTZ,
+      password_hash TEXT,
+      max_clicks INTEGER,
+      clicks BIGINT,
+      page_meta JSONB,
+      health JSONB,
+      disabled BOOLEAN,
+      position INTEGER
+    ) ON COMMIT DROP
+  `
+
+	insertBatchQuery = `
+    INSERT INTO urls (id, short_url, original_url, user_id, created_at, allow_duplicate, tag, metadata, redirect_type, expires_at, password_hash, max_clicks, clicks, page_meta, health, disabled)
+    SELECT id, short_url, original_url, user_id, created_at, allow_duplicate, tag, metadata, redirect_type, expires_at, password_hash, max_clicks, clicks, page_meta, health, disabled
+    FROM urls_batch ORDER BY position
+    ON CONFLICT (original_url) WHERE NOT allow_duplicate DO NOTHING
+    RETURNING short_url
+  `
+
+	batchConflictsQuery = `SELECT original_url, short_url FROM urls WHERE original_url = ANY($1) AND NOT allow_duplicate`
+)
+
+func copyBatch(ctx context.Context, tx *sql.Tx, items []URLData) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(batchTable, batchColumns...))
+	if err != nil {
+		return fmt.Errorf("could not prepare copy: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, item := range items {
+		metadata, err := encodeMetadata(item.Metadata)
+		if err != nil {
+			return err
+		}
+		pageMeta, err := encodeJSONB(item.PageMeta)
+		if err != nil {
+			return err
+		}
+		health, err := encodeJSONB(item.Health)
 		if err != nil {
-			existingShortURL, fetchErr := s.getShortURLByOriginal(item.OriginalURL)
-			if fetchErr != nil {
-				return nil, fmt.Errorf("could not insert or fetch URL: %w", err)
-			}
-			return nil, &ErrURLExists{ExistingShortURL: existingShortURL, ID: item.CorrelationID}
+			return err
+		}
+		_, err = stmt.ExecContext(ctx,
+			item.UUID, item.ShortURL, item.OriginalURL, item.UserID, item.CreatedAt,
+			item.AllowDuplicate, item.Tag, metadata, item.RedirectType, item.ExpiresAt, item.PasswordHash,
+			item.MaxClicks, item.Clicks, pageMeta, health, item.Disabled, i,
+		)
+		if err != nil {
+			return fmt.Errorf("could not copy URL %s: %w", item.OriginalURL, err)
+		}
+	}
+
+	// пустой Exec завершает COPY и отправляет буфер на сервер
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("could not flush copy: %w", err)
+	}
+
+	return nil
+}
+
+func insertBatch(ctx context.Context, tx *sql.Tx) (map[string]struct{}, error) {
+	rows, err := tx.QueryContext(ctx, insertBatchQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not insert batch: %w", err)
+	}
+	defer rows.Close()
+
+	inserted := make(map[string]struct{})
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			return nil, fmt.Errorf("could not scan inserted URL: %w", err)
+		}
+		inserted[shortURL] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not insert batch: %w", err)
+	}
+
+	return inserted, nil
+}
+
+// batchConflicts собирает элементы пачки, которые не были вставлены из-за
+// уже существующего original_url, вместе с их существующими short_url.
+// Повтор исходного URL внутри пачки, как в InMemoryStore, указывает на
+// элемент этой же пачки, а не на ссылку из хранилища.
+func (s *PostgresStore) batchConflicts(ctx context.Context, tx *sql.Tx, items []URLData, inserted map[string]struct{}) error {
+	inBatch := make(map[string]string, len(inserted))
+	for _, item := range items {
+		if _, ok := inserted[item.ShortURL]; !ok || item.AllowDuplicate {
+			continue
 		}
+		if _, ok := inBatch[item.OriginalURL]; !ok {
+			inBatch[item.OriginalURL] = item.ShortURL
+		}
+	}
 
-		urlDataList = append(urlDataList, URLData{
-			UUID:        item.CorrelationID,
-			ShortURL:    returnedShortURL,
+	var originals []string
+	for _, item := range items {
+		if _, ok := inserted[item.ShortURL]; ok {
+			continue
+		}
+		if _, ok := inBatch[item.OriginalURL]; !ok {
+			originals = append(originals, item.OriginalURL)
+		}
+	}
+
+	existing, err := s.existingShortURLs(ctx, tx, originals)
+	if err != nil {
+		return err
+	}
+
+	conflictErr := &ErrURLExists{}
+	for _, item := range items {
+		if _, ok := inserted[item.ShortURL]; ok {
+			continue
+		}
+		shortURL, ok := inBatch[item.OriginalURL]
+		if !ok {
+			shortURL, ok = existing[item.OriginalURL]
+		}
+		if !ok {
+			return fmt.Errorf("could not insert URL %s", item.OriginalURL)
+		}
+		conflictErr.Conflicts = append(conflictErr.Conflicts, URLData{
+			UUID:        item.UUID,
+			ShortURL:    shortURL,
 			OriginalURL: item.OriginalURL,
 		})
 	}
 
-	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	// пропуск строк не объясняется конфликтом original_url,
+	// например в пачке повторился short_url
+	if len(conflictErr.Conflicts) == 0 {
+		return errors.New("could not insert batch: rows were skipped without an original_url conflict")
 	}
+	conflictErr.ID = conflictErr.Conflicts[0].UUID
+	conflictErr.ExistingShortURL = conflictErr.Conflicts[0].ShortURL
 
-	return urlDataList, nil
+	return conflictErr
 }
 
-func (s *PostgresStore) Get(shortURL string) (string, error) {
-	var originalURL string
-	query := `SELECT original_url FROM urls WHERE short_url = $1`
+// existingShortURLs возвращает short_url уже сохранённых ссылок
+// по исходным URL из originals.
+func (s *PostgresStore) existingShortURLs(ctx context.Context, tx *sql.Tx, originals []string) (map[string]string, error) {
+	existing := make(map[string]string, len(originals))
+	if len(originals) == 0 {
+		return existing, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, batchConflictsQuery, pq.Array(originals))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch existing short URLs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var originalURL, shortURL string
+		if err := rows.Scan(&originalURL, &shortURL); err != nil {
+			return nil, fmt.Errorf("could not scan existing short URL: %w", err)
+		}
+		existing[originalURL] = shortURL
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not fetch existing short URLs: %w", err)
+	}
+	return existing, nil
+}
+
+func (s *PostgresStore) Get(ctx context.Context, shortURL string) (_ URLData, err error) {
+	ctx, span := startSpan(ctx, "Get")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE short_url = $1`
+
+	data, err := scanURL(s.db.QueryRowContext(ctx, query, shortURL))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return URLData{}, ErrNotFound
+		}
+		return URLData{}, fmt.Errorf("could not get url: %w", err)
+	}
+
+	return data, nil
+}
+
+const (
+	selectForUpdateQuery = `SELECT ` + urlColumns + ` FROM urls WHERE short_url = $1 FOR UPDATE`
+
+	originalConflictQuery = `SELECT short_url FROM urls WHERE original_url = $1 AND NOT allow_duplicate AND short_url <> $2`
+
+	insertHistoryQuery = `
+    INSERT INTO url_history (short_url, original_url, redirect_type, expires_at, changed_by, changed_at)
+    VALUES ($1, $2, $3, $4, $5, $6)
+  `
+
+	updateURLQuery = `UPDATE urls SET original_url = $1, redirect_type = $2, expires_at = $3, page_meta = $4, health = $5 WHERE short_url = $6`
+
+	historyQuery = `
+    SELECT short_url, original_url, redirect_type, expires_at, changed_by, changed_at
+    FROM url_history WHERE short_url = $1 ORDER BY id
+  `
+)
+
+func (s *PostgresStore) Update(ctx context.Context, shortURL, userID string, upd URLUpdate) (_ URLData, err error) {
+	ctx, span := startSpan(ctx, "Update")
+	defer func() { endSpan(span, err) }()
 
-	err := s.db.QueryRow(query, shortURL).Scan(&originalURL)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return URLData{}, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// строка блокируется до конца транзакции, чтобы параллельные
+	// изменения не потеряли запись в истории
+	old, err := scanURL(tx.QueryRowContext(ctx, selectForUpdateQuery, shortURL))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", errors.New("url not found")
+			return URLData{}, ErrNotFound
 		}
-		return "", fmt.Errorf("could not get url: %w", err)
+		return URLData{}, fmt.Errorf("could not get url: %w", err)
+	}
+	if old.UserID == "" || old.UserID != userID {
+		return URLData{}, ErrForbidden
 	}
 
-	return originalURL, nil
+	updated := upd.apply(old)
+	if updated.OriginalURL != old.OriginalURL && !updated.AllowDuplicate {
+		var existing string
+		err := tx.QueryRowContext(ctx, originalConflictQuery, updated.OriginalURL, shortURL).Scan(&existing)
+		if err == nil {
+			return URLData{}, &ErrURLExists{ExistingShortURL: existing, ID: old.UUID}
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return URLData{}, fmt.Errorf("could not check original URL: %w", err)
+		}
+	}
+
+	entry := newHistoryEntry(old, userID)
+	_, err = tx.ExecContext(ctx,
+		insertHistoryQuery, entry.ShortURL, entry.OriginalURL, entry.RedirectType,
+		entry.ExpiresAt, entry.ChangedBy, entry.ChangedAt,
+	)
+	if err != nil {
+		return URLData{}, fmt.Errorf("could not save url history: %w", err)
+	}
+
+	pageMeta, err := encodeJSONB(updated.PageMeta)
+	if err != nil {
+		return URLData{}, err
+	}
+	health, err := encodeJSONB(updated.Health)
+	if err != nil {
+		return URLData{}, err
+	}
+	_, err = tx.ExecContext(ctx, updateURLQuery,
+		updated.OriginalURL, updated.RedirectType, updated.ExpiresAt, pageMeta, health, shortURL,
+	)
+	if err != nil {
+		return URLData{}, fmt.Errorf("could not update url: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return URLData{}, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return updated, nil
+}
+
+const (
+	// clickQuery увеличивает счётчик одной командой: условие на max_clicks
+	// проверяется под блокировкой строки, поэтому лимит не превышается
+	// при параллельных переходах
+	clickQuery = `
+    UPDATE urls SET clicks = clicks + 1
+    WHERE short_url = $1 AND (max_clicks = 0 OR clicks < max_clicks)
+    RETURNING ` + urlColumns
+
+	urlExistsQuery = `SELECT EXISTS (SELECT 1 FROM urls WHERE short_url = $1)`
+)
+
+func (s *PostgresStore) Click(ctx context.Context, shortURL string) (_ URLData, err error) {
+	ctx, span := startSpan(ctx, "Click")
+	defer func() { endSpan(span, err) }()
+
+	data, err := scanURL(s.db.QueryRowContext(ctx, clickQuery, shortURL))
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return URLData{}, fmt.Errorf("could not count click: %w", err)
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, urlExistsQuery, shortURL).Scan(&exists); err != nil {
+		return URLData{}, fmt.Errorf("could not get url: %w", err)
+	}
+	if !exists {
+		return URLData{}, ErrNotFound
+	}
+	return URLData{}, ErrClickLimitReached
+}
+
+const (
+	setPageMetaQuery = `UPDATE urls SET page_meta = $1 WHERE short_url = $2`
+	setHealthQuery   = `UPDATE urls SET health = $1 WHERE short_url = $2`
+	setDisabledQuery = `UPDATE urls SET disabled = $1 WHERE short_url = $2`
+)
+
+func (s *PostgresStore) SetPageMeta(ctx context.Context, shortURL string, meta PageMeta) (err error) {
+	ctx, span := startSpan(ctx, "SetPageMeta")
+	defer func() { endSpan(span, err) }()
+
+	pageMeta, err := encodeJSONB(&meta)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, setPageMetaQuery, pageMeta, shortURL)
+	if err != nil {
+		return fmt.Errorf("could not save page meta: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetHealth(ctx context.Context, shortURL string, health LinkHealth) (err error) {
+	ctx, span := startSpan(ctx, "SetHealth")
+	defer func() { endSpan(span, err) }()
+
+	value, err := encodeJSONB(&health)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, setHealthQuery, value, shortURL)
+	if err != nil {
+		return fmt.Errorf("could not save health: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetDisabled(ctx context.Context, shortURL string, disabled bool) (err error) {
+	ctx, span := startSpan(ctx, "SetDisabled")
+	defer func() { endSpan(span, err) }()
+
+	res, err := s.db.ExecContext(ctx, setDisabledQuery, disabled, shortURL)
+	if err != nil {
+		return fmt.Errorf("could not update url: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const (
+	deleteURLQuery     = `DELETE FROM urls WHERE short_url = $1`
+	deleteHistoryQuery = `DELETE FROM url_history WHERE short_url = $1`
+)
+
+func (s *PostgresStore) Delete(ctx context.Context, shortURL string) (err error) {
+	ctx, span := startSpan(ctx, "Delete")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, deleteURLQuery, shortURL)
+	if err != nil {
+		return fmt.Errorf("could not delete url: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, deleteHistoryQuery, shortURL); err != nil {
+		return fmt.Errorf("could not delete url history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// startSpan открывает спан операции с базой данных.
+func startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "PostgresStore."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", op),
+		),
+	)
+}
+
+// endSpan завершает спан. Ожидаемые ответы хранилища — отсутствие ссылки,
+// конфликт, чужая ссылка — ошибкой спана не считаются.
+func endSpan(span trace.Span, err error) {
+	var existsErr *ErrURLExists
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrAPIKeyNotFound) || errors.Is(err, ErrForbidden) ||
+		errors.Is(err, ErrClickLimitReached) || errors.As(err, &existsErr) {
+		span.SetAttributes(attribute.String("db.result", err.Error()))
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE, чтобы подстрока искалась буквально.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+const (
+	apiKeyColumns = "id, user_id, name, prefix, hash, scopes, created_at"
+
+	insertAPIKeyQuery = `INSERT INTO api_keys (` + apiKeyColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	getAPIKeyQuery    = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE hash = $1`
+	listAPIKeysQuery  = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 ORDER BY created_at, id`
+	allAPIKeysQuery   = `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY created_at, id`
+	deleteAPIKeyQuery = `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`
+)
+
+func (s *PostgresStore) SaveAPIKey(ctx context.Context, key APIKey) (err error) {
+	ctx, span := startSpan(ctx, "SaveAPIKey")
+	defer func() { endSpan(span, err) }()
+
+	_, err = s.db.ExecContext(ctx,
+		insertAPIKeyQuery, key.ID, key.UserID, key.Name, key.Prefix, key.Hash,
+		pq.Array(key.Scopes), key.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not save api key: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetAPIKey(ctx context.Context, hash string) (_ APIKey, err error) {
+	ctx, span := startSpan(ctx, "GetAPIKey")
+	defer func() { endSpan(span, err) }()
+
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, getAPIKeyQuery, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("could not get api key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, userID string) (_ []APIKey, err error) {
+	ctx, span := startSpan(ctx, "ListAPIKeys")
+	defer func() { endSpan(span, err) }()
+
+	return s.queryAPIKeys(ctx, listAPIKeysQuery, userID)
+}
+
+func (s *PostgresStore) AllAPIKeys(ctx context.Context) (_ []APIKey, err error) {
+	ctx, span := startSpan(ctx, "AllAPIKeys")
+	defer func() { endSpan(span, err) }()
+
+	return s.queryAPIKeys(ctx, allAPIKeysQuery)
+}
+
+func (s *PostgresStore) queryAPIKeys(ctx context.Context, query string, args ...interface{}) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *PostgresStore) DeleteAPIKey(ctx context.Context, id, userID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteAPIKey")
+	defer func() { endSpan(span, err) }()
+
+	// id — UUID-столбец: строка в другом формате не может совпасть с ключом
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrAPIKeyNotFound
+	}
+	res, err := s.db.ExecContext(ctx, deleteAPIKeyQuery, id, userID)
+	if err != nil {
+		return fmt.Errorf("could not delete api key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash,
+		pq.Array(&key.Scopes), &key.CreatedAt,
+	)
+	return key, err
+}
+
+func (s *PostgresStore) History(ctx context.Context, shortURL string) (_ []HistoryEntry, err error) {
+	ctx, span := startSpan(ctx, "History")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.db.QueryContext(ctx, historyQuery, shortURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not get url history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		var expiresAt sql.NullTime
+		err := rows.Scan(
+			&entry.ShortURL, &entry.OriginalURL, &entry.RedirectType,
+			&expiresAt, &entry.ChangedBy, &entry.ChangedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan url history: %w", err)
+		}
+		if expiresAt.Valid {
+			entry.ExpiresAt = &expiresAt.Time
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get url history: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (s *PostgresStore) SetHistory(ctx context.Context, shortURL string, entries []HistoryEntry) (err error) {
+	ctx, span := startSpan(ctx, "SetHistory")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, deleteHistoryQuery, shortURL); err != nil {
+		return fmt.Errorf("could not delete url history: %w", err)
+	}
+	for _, entry := range entries {
+		_, err := tx.ExecContext(ctx,
+			insertHistoryQuery, shortURL, entry.OriginalURL, entry.RedirectType,
+			entry.ExpiresAt, entry.ChangedBy, entry.ChangedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("could not save url history: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) List(ctx context.Context, filter ListFilter) (_ []URLData, err error) {
+	ctx, span := startSpan(ctx, "List")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE short_url > $1`
+	args := []interface{}{filter.After}
+
+	if filter.UserID != "" {
+		args = append(args, filter.UserID)
+		query += fmt.Sprintf(" AND user_id = $%d", len(args))
+	}
+	if filter.OriginalContains != "" {
+		args = append(args, "%"+escapeLike(filter.OriginalContains)+"%")
+		query += fmt.Sprintf(" AND original_url ILIKE $%d", len(args))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+	query += " ORDER BY short_url"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list urls: %w", err)
+	}
+	defer rows.Close()
+
+	var page []URLData
+	for rows.Next() {
+		data, err := scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, data)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list urls: %w", err)
+	}
+
+	return page, nil
 }
 
 func (s *PostgresStore) LoadFromFile(_ string) error {
@@ -121,12 +863,78 @@ func (s *PostgresStore) SaveToFile(_ string) error {
 	return nil
 }
 
-func (s *PostgresStore) getShortURLByOriginal(originalURL string) (string, error) {
+func (s *PostgresStore) getShortURLByOriginal(ctx context.Context, originalURL string) (string, error) {
 	var shortURL string
-	query := `SELECT short_url FROM urls WHERE original_url = $1`
-	err := s.db.QueryRow(query, originalURL).Scan(&shortURL)
+	query := `SELECT short_url FROM urls WHERE original_url = $1 AND NOT allow_duplicate`
+	err := s.db.QueryRowContext(ctx, query, originalURL).Scan(&shortURL)
 	if err != nil {
 		return "", fmt.Errorf("could not fetch short URL by original URL: %w", err)
 	}
 	return shortURL, nil
 }
+
+const urlColumns = "id, short_url, original_url, user_id, created_at, allow_duplicate, tag, metadata, redirect_type, expires_at, password_hash, max_clicks, clicks, page_meta, health, disabled"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanURL(row rowScanner) (URLData, error) {
+	var data URLData
+	var metadata []byte
+	var expiresAt sql.NullTime
+	var pageMeta, health []byte
+	err := row.Scan(
+		&data.UUID, &data.ShortURL, &data.OriginalURL, &data.UserID, &data.CreatedAt,
+		&data.AllowDuplicate, &data.Tag, &metadata, &data.RedirectType, &expiresAt, &data.PasswordHash,
+		&data.MaxClicks, &data.Clicks, &pageMeta, &health, &data.Disabled,
+	)
+	if err != nil {
+		return data, fmt.Errorf("could not scan url: %w", err)
+	}
+	if expiresAt.Valid {
+		data.ExpiresAt = &expiresAt.Time
+	}
+	if len(pageMeta) > 0 {
+		data.PageMeta = &PageMeta{}
+		if err := json.Unmarshal(pageMeta, data.PageMeta); err != nil {
+			return data, fmt.Errorf("could not decode page meta: %w", err)
+		}
+	}
+	if len(health) > 0 {
+		data.Health = &LinkHealth{}
+		if err := json.Unmarshal(health, data.Health); err != nil {
+			return data, fmt.Errorf("could not decode health: %w", err)
+		}
+	}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &data.Metadata); err != nil {
+			return data, fmt.Errorf("could not decode metadata: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// encodeMetadata возвращает JSON для колонки metadata или nil для пустых метаданных.
+func encodeMetadata(metadata map[string]string) (interface{}, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode metadata: %w", err)
+	}
+	return string(b), nil
+}
+
+// encodeJSONB возвращает JSON для JSONB-колонки или nil, если значения нет.
+func encodeJSONB[T any](v *T) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode %T: %w", *v, err)
+	}
+	return string(b), nil
+}
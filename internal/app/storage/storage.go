@@ -2,13 +2,16 @@ package storage
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/condratf/shortner/internal/app/models"
 	"github.com/google/uuid"
 )
 
@@ -20,29 +23,311 @@ const (
 )
 
 type URLData struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	UUID        string    `json:"uuid"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	UserID      string    `json:"user_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// AllowDuplicate помечает дополнительную ссылку на уже сокращённый URL:
+	// такие ссылки не участвуют в проверке дубликатов.
+	AllowDuplicate bool              `json:"allow_duplicate,omitempty"`
+	Tag            string            `json:"tag,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	// RedirectType — HTTP-код редиректа; 0 означает код по умолчанию.
+	RedirectType int        `json:"redirect_type,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	// PasswordHash — bcrypt-хеш пароля; непустой хеш закрывает ссылку формой ввода пароля.
+	PasswordHash string `json:"password_hash,omitempty"`
+	// MaxClicks ограничивает число переходов; 0 — без ограничения.
+	MaxClicks int   `json:"max_clicks,omitempty"`
+	Clicks    int64 `json:"clicks,omitempty"`
+	// PageMeta — заголовок и og:*-теги страницы назначения, nil пока не получены.
+	PageMeta *PageMeta `json:"page_meta,omitempty"`
+	// Health — результат последней проверки доступности назначения.
+	Health *LinkHealth `json:"health,omitempty"`
+	// Disabled — ссылка отключена модератором и не открывается.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// PageMeta — сведения о странице назначения для превью в мессенджерах.
+type PageMeta struct {
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	Favicon     string    `json:"favicon,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// LinkHealth — результат проверки страницы назначения. Status — итоговый
+// HTTP-код после редиректов, Error — ошибка сети, если ответа не было.
+type LinkHealth struct {
+	Status    int       `json:"status,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Broken сообщает, что назначение не отвечает или отвечает ошибкой.
+func (h LinkHealth) Broken() bool {
+	return h.Error != "" || h.Status >= 400
+}
+
+// Expired сообщает, истёк ли срок действия ссылки к моменту now.
+func (d URLData) Expired(now time.Time) bool {
+	return d.ExpiresAt != nil && !now.Before(*d.ExpiresAt)
+}
+
+// Restricted сообщает, что переход ограничен паролем, лимитом переходов или
+// сроком действия: адрес назначения нельзя показывать в обход Click.
+func (d URLData) Restricted() bool {
+	return d.PasswordHash != "" || d.MaxClicks > 0 || d.ExpiresAt != nil
 }
 
 type UUID = string
 
+var (
+	ErrNotFound          = errors.New("url not found")
+	ErrForbidden         = errors.New("url belongs to another user")
+	ErrClickLimitReached = errors.New("click limit reached")
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	// ErrShortURLTaken — ключ уже занят: SaveBatch любого хранилища
+	// отклоняет такую пачку целиком, не перезаписывая существующую ссылку.
+	ErrShortURLTaken = errors.New("short url already exists")
+)
+
+// APIKey — ключ доступа к API для серверных клиентов. Сам ключ не хранится:
+// Hash — его SHA-256, Prefix — начало ключа, по которому его узнаёт владелец.
+type APIKey struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name,omitempty"`
+	Prefix    string    `json:"prefix"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// URLUpdate — изменения ссылки; nil-поля остаются прежними.
+// ClearExpiry снимает срок действия и имеет приоритет над ExpiresAt.
+type URLUpdate struct {
+	OriginalURL  *string
+	RedirectType *int
+	ExpiresAt    *time.Time
+	ClearExpiry  bool
+}
+
+func (u URLUpdate) apply(data URLData) URLData {
+	if u.OriginalURL != nil && *u.OriginalURL != data.OriginalURL {
+		data.OriginalURL = *u.OriginalURL
+		// превью и результат проверки относятся к прежнему адресу
+		data.PageMeta = nil
+		data.Health = nil
+	}
+	if u.RedirectType != nil {
+		data.RedirectType = *u.RedirectType
+	}
+	if u.ExpiresAt != nil {
+		expiresAt := *u.ExpiresAt
+		data.ExpiresAt = &expiresAt
+	}
+	if u.ClearExpiry {
+		data.ExpiresAt = nil
+	}
+	return data
+}
+
+// HistoryEntry — предыдущее состояние ссылки, сохранённое при её изменении.
+type HistoryEntry struct {
+	ShortURL     string     `json:"short_url"`
+	OriginalURL  string     `json:"original_url"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	ChangedBy    string     `json:"changed_by"`
+	ChangedAt    time.Time  `json:"changed_at"`
+}
+
+func newHistoryEntry(old URLData, changedBy string) HistoryEntry {
+	return HistoryEntry{
+		ShortURL:     old.ShortURL,
+		OriginalURL:  old.OriginalURL,
+		RedirectType: old.RedirectType,
+		ExpiresAt:    old.ExpiresAt,
+		ChangedBy:    changedBy,
+		ChangedAt:    time.Now().UTC(),
+	}
+}
+
+// ListFilter задаёт выборку для постраничного обхода ссылок.
+// Записи упорядочены по short_url; After — курсор, то есть short_url
+// последней записи предыдущей страницы. Интервал дат [From, To)
+// не ограничен с той стороны, где значение нулевое. Непустой
+// OriginalContains оставляет ссылки, исходный URL которых содержит
+// эту подстроку без учёта регистра.
+type ListFilter struct {
+	UserID           string
+	OriginalContains string
+	From             time.Time
+	To               time.Time
+	After            string
+	Limit            int
+}
+
+func (f ListFilter) match(data URLData) bool {
+	if f.UserID != "" && data.UserID != f.UserID {
+		return false
+	}
+	if f.OriginalContains != "" && !strings.Contains(strings.ToLower(data.OriginalURL), strings.ToLower(f.OriginalContains)) {
+		return false
+	}
+	if !f.From.IsZero() && data.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !data.CreatedAt.Before(f.To) {
+		return false
+	}
+	return data.ShortURL > f.After
+}
+
 type Storage interface {
-	Save(shortURL, originalURL string) (UUID, error)
-	SaveBatch([]models.BatchItem) ([]URLData, error)
-	Get(id string) (string, error)
+	Save(ctx context.Context, data URLData) (UUID, error)
+	SaveBatch(ctx context.Context, items []URLData) ([]URLData, error)
+	// Get возвращает ссылку по ключу или ErrNotFound для неизвестного ключа.
+	Get(ctx context.Context, id string) (URLData, error)
+	List(ctx context.Context, filter ListFilter) ([]URLData, error)
+	// Update меняет ссылку пользователя userID и записывает её прежнее
+	// состояние в историю. Возвращает ErrNotFound для неизвестного ключа
+	// и ErrForbidden для чужой ссылки.
+	Update(ctx context.Context, shortURL, userID string, upd URLUpdate) (URLData, error)
+	// History возвращает прежние состояния ссылки, начиная с самого раннего.
+	History(ctx context.Context, shortURL string) ([]HistoryEntry, error)
+	// SetHistory заменяет историю ссылки записями entries; нужен при переносе
+	// данных между хранилищами, поэтому повторный вызов ничего не дублирует.
+	SetHistory(ctx context.Context, shortURL string, entries []HistoryEntry) error
+	// Click атомарно учитывает переход по ссылке и возвращает её с новым
+	// счётчиком. Если лимит MaxClicks исчерпан, счётчик не меняется
+	// и возвращается ErrClickLimitReached.
+	Click(ctx context.Context, shortURL string) (URLData, error)
+	// SetPageMeta сохраняет сведения о странице назначения ссылки.
+	SetPageMeta(ctx context.Context, shortURL string, meta PageMeta) error
+	// SetHealth сохраняет результат проверки страницы назначения ссылки.
+	SetHealth(ctx context.Context, shortURL string, health LinkHealth) error
+	// SetDisabled отключает ссылку или снова включает её.
+	SetDisabled(ctx context.Context, shortURL string, disabled bool) error
+	// Delete безвозвратно удаляет ссылку вместе с её историей.
+	Delete(ctx context.Context, shortURL string) error
+	// SaveAPIKey сохраняет новый ключ доступа.
+	SaveAPIKey(ctx context.Context, key APIKey) error
+	// GetAPIKey ищет ключ по хешу и возвращает ErrAPIKeyNotFound для неизвестного.
+	GetAPIKey(ctx context.Context, hash string) (APIKey, error)
+	// ListAPIKeys возвращает ключи пользователя в порядке создания.
+	ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	// AllAPIKeys возвращает ключи всех пользователей в порядке создания.
+	AllAPIKeys(ctx context.Context) ([]APIKey, error)
+	// DeleteAPIKey отзывает ключ пользователя. Чужой ключ не отличается
+	// от отсутствующего: в обоих случаях возвращается ErrAPIKeyNotFound.
+	DeleteAPIKey(ctx context.Context, id, userID string) error
 	LoadFromFile(filePath string) error
 	SaveToFile(filePath string) error
 }
 
+const defaultListLimit = 100
+
+// Iterate обходит все ссылки, подходящие под filter, страницами по filter.Limit,
+// не загружая выборку в память целиком.
+func Iterate(ctx context.Context, s Storage, filter ListFilter, fn func(URLData) error) error {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+
+	for {
+		page, err := s.List(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, data := range page {
+			if err := fn(data); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < filter.Limit {
+			return nil
+		}
+		filter.After = page[len(page)-1].ShortURL
+	}
+}
+
+// SaveBatchSkippingConflicts сохраняет items через SaveBatch, убирая из пачки
+// элементы, чей исходный URL уже сохранён. Возвращает сохранённые записи
+// и конфликты с существующими short_url (UUID конфликта — UUID элемента).
+func SaveBatchSkippingConflicts(ctx context.Context, s Storage, items []URLData) ([]URLData, []URLData, error) {
+	var skipped []URLData
+
+	for len(items) > 0 {
+		saved, err := s.SaveBatch(ctx, items)
+		if err == nil {
+			return saved, skipped, nil
+		}
+
+		var existsErr *ErrURLExists
+		if !errors.As(err, &existsErr) {
+			return nil, skipped, err
+		}
+
+		conflicts := existsErr.Conflicts
+		if len(conflicts) == 0 {
+			conflicts = []URLData{{UUID: existsErr.ID, ShortURL: existsErr.ExistingShortURL}}
+		}
+		existing := make(map[string]URLData, len(conflicts))
+		for _, conflict := range conflicts {
+			existing[conflict.UUID] = conflict
+		}
+
+		remaining := make([]URLData, 0, len(items))
+		for _, item := range items {
+			conflict, ok := existing[item.UUID]
+			if !ok {
+				remaining = append(remaining, item)
+				continue
+			}
+			conflict.OriginalURL = item.OriginalURL
+			skipped = append(skipped, conflict)
+		}
+		if len(remaining) == len(items) {
+			return nil, skipped, err
+		}
+		items = remaining
+	}
+
+	return nil, skipped, nil
+}
+
 type InMemoryStore struct {
 	data map[string]URLData
-	mu   sync.RWMutex
+	// originals — обратный индекс original_url -> short_url
+	// для обнаружения дубликатов, как UNIQUE (original_url) в PostgresStore
+	originals map[string]string
+	// keys — short_url всех ссылок по возрастанию: List начинает страницу
+	// с курсора, не перебирая и не сортируя всю карту
+	keys []string
+	// history и apiKeys SaveToFile пишет в отдельные файлы рядом с файлом
+	// ссылок, см. HistoryFilePath и APIKeysFilePath
+	history map[string][]HistoryEntry
+	// apiKeys — ключи доступа по хешу
+	apiKeys map[string]APIKey
+	mu      sync.RWMutex
+	// fileMu не даёт параллельным SaveToFile перемешать содержимое файла
+	fileMu sync.Mutex
 }
 
 type ErrURLExists struct {
 	ID               string
 	ExistingShortURL string
+	// Conflicts перечисляет все элементы пачки, чей original_url уже сохранён,
+	// с существующими short_url; ID и ExistingShortURL совпадают с первым из них.
+	Conflicts []URLData
 }
 
 func (e *ErrURLExists) Error() string {
@@ -55,84 +340,419 @@ func (e *ErrURLExists) Is(target error) bool {
 }
 
 func NewInMemoryStore() Storage {
-	return &InMemoryStore{data: make(map[string]URLData)}
+	return &InMemoryStore{
+		data:      make(map[string]URLData),
+		originals: make(map[string]string),
+		history:   make(map[string][]HistoryEntry),
+		apiKeys:   make(map[string]APIKey),
+	}
 }
 
-func (s *InMemoryStore) Save(shortURL, originalURL string) (string, error) {
-	id := uuid.New().String()
+func (s *InMemoryStore) Save(ctx context.Context, data URLData) (string, error) {
+	if data.UUID == "" {
+		data.UUID = uuid.New().String()
+	}
+	if data.CreatedAt.IsZero() {
+		data.CreatedAt = time.Now().UTC()
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.data[shortURL] = URLData{
-		UUID:        id,
-		ShortURL:    shortURL,
-		OriginalURL: originalURL,
+	if existing, ok := s.originals[data.OriginalURL]; ok && !data.AllowDuplicate {
+		return "", &ErrURLExists{ExistingShortURL: existing, ID: data.UUID}
+	}
+
+	s.put(data)
+	return data.UUID, nil
+}
+
+// put сохраняет запись и обновляет обратный индекс; вызывается под s.mu.
+func (s *InMemoryStore) put(data URLData) {
+	old, ok := s.data[data.ShortURL]
+	if ok && s.originals[old.OriginalURL] == old.ShortURL {
+		delete(s.originals, old.OriginalURL)
+	}
+	if !ok {
+		i := sort.SearchStrings(s.keys, data.ShortURL)
+		s.keys = append(s.keys, "")
+		copy(s.keys[i+1:], s.keys[i:])
+		s.keys[i] = data.ShortURL
+	}
+	s.data[data.ShortURL] = data
+	if data.AllowDuplicate {
+		return
+	}
+	if _, ok := s.originals[data.OriginalURL]; !ok {
+		s.originals[data.OriginalURL] = data.ShortURL
 	}
-	return id, nil
 }
 
-func (s *InMemoryStore) SaveBatch(items []models.BatchItem) ([]URLData, error) {
+func (s *InMemoryStore) SaveBatch(ctx context.Context, items []URLData) ([]URLData, error) {
 	var urlDataList []URLData
+	now := time.Now().UTC()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, item := range items {
-		urlData := URLData{
-			UUID:        item.CorrelationID,
-			ShortURL:    item.ShortURL,
-			OriginalURL: item.OriginalURL,
+	// исходные URL и ключи, добавленные этой же пачкой
+	inBatch := make(map[string]string, len(items))
+	batchKeys := make(map[string]struct{}, len(items))
+	conflictErr := &ErrURLExists{}
+
+	for _, urlData := range items {
+		if !urlData.AllowDuplicate {
+			existing, ok := s.originals[urlData.OriginalURL]
+			if !ok {
+				existing, ok = inBatch[urlData.OriginalURL]
+			}
+			if ok {
+				conflictErr.Conflicts = append(conflictErr.Conflicts, URLData{
+					UUID:        urlData.UUID,
+					ShortURL:    existing,
+					OriginalURL: urlData.OriginalURL,
+				})
+				continue
+			}
+			inBatch[urlData.OriginalURL] = urlData.ShortURL
+		}
+
+		// как BoltStore и UNIQUE (short_url) в PostgresStore
+		if _, ok := s.data[urlData.ShortURL]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrShortURLTaken, urlData.ShortURL)
+		}
+		if _, ok := batchKeys[urlData.ShortURL]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrShortURLTaken, urlData.ShortURL)
+		}
+		batchKeys[urlData.ShortURL] = struct{}{}
+
+		if urlData.CreatedAt.IsZero() {
+			urlData.CreatedAt = now
 		}
 		urlDataList = append(urlDataList, urlData)
-		s.data[item.ShortURL] = urlData
+	}
+
+	// как и в PostgresStore, пачка с конфликтом не сохраняется целиком
+	if len(conflictErr.Conflicts) > 0 {
+		conflictErr.ID = conflictErr.Conflicts[0].UUID
+		conflictErr.ExistingShortURL = conflictErr.Conflicts[0].ShortURL
+		return nil, conflictErr
+	}
+
+	for _, urlData := range urlDataList {
+		s.put(urlData)
 	}
 	return urlDataList, nil
 }
 
-func (s *InMemoryStore) Get(shortURL string) (string, error) {
+func (s *InMemoryStore) Get(ctx context.Context, shortURL string) (URLData, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	urlData, ok := s.data[shortURL]
 	if !ok {
-		return "", errors.New("url not found")
+		return URLData{}, ErrNotFound
 	}
-	return urlData.OriginalURL, nil
+	return urlData, nil
 }
 
-func (s *InMemoryStore) LoadFromFile(filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
+func (s *InMemoryStore) Update(ctx context.Context, shortURL, userID string, upd URLUpdate) (URLData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.data[shortURL]
+	if !ok {
+		return URLData{}, ErrNotFound
+	}
+	if old.UserID == "" || old.UserID != userID {
+		return URLData{}, ErrForbidden
+	}
+
+	updated := upd.apply(old)
+	if updated.OriginalURL != old.OriginalURL && !updated.AllowDuplicate {
+		if existing, ok := s.originals[updated.OriginalURL]; ok {
+			return URLData{}, &ErrURLExists{ExistingShortURL: existing, ID: old.UUID}
+		}
+	}
+
+	s.history[shortURL] = append(s.history[shortURL], newHistoryEntry(old, userID))
+	s.put(updated)
+	return updated, nil
+}
+
+func (s *InMemoryStore) Click(ctx context.Context, shortURL string) (URLData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.data[shortURL]
+	if !ok {
+		return URLData{}, ErrNotFound
+	}
+	if data.MaxClicks > 0 && data.Clicks >= int64(data.MaxClicks) {
+		return URLData{}, ErrClickLimitReached
+	}
+	data.Clicks++
+	s.data[shortURL] = data
+	return data, nil
+}
+
+func (s *InMemoryStore) SetPageMeta(ctx context.Context, shortURL string, meta PageMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.data[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	data.PageMeta = &meta
+	s.data[shortURL] = data
+	return nil
+}
+
+func (s *InMemoryStore) SetHealth(ctx context.Context, shortURL string, health LinkHealth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.data[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	data.Health = &health
+	s.data[shortURL] = data
+	return nil
+}
+
+func (s *InMemoryStore) SetDisabled(ctx context.Context, shortURL string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.data[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	data.Disabled = disabled
+	s.data[shortURL] = data
+	return nil
+}
+
+func (s *InMemoryStore) Delete(ctx context.Context, shortURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.data[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.data, shortURL)
+	if i := sort.SearchStrings(s.keys, shortURL); i < len(s.keys) && s.keys[i] == shortURL {
+		s.keys = append(s.keys[:i], s.keys[i+1:]...)
+	}
+	if s.originals[data.OriginalURL] == shortURL {
+		delete(s.originals, data.OriginalURL)
+	}
+	delete(s.history, shortURL)
+	return nil
+}
+
+func (s *InMemoryStore) History(ctx context.Context, shortURL string) ([]HistoryEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]HistoryEntry(nil), s.history[shortURL]...), nil
+}
+
+func (s *InMemoryStore) SetHistory(ctx context.Context, shortURL string, entries []HistoryEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(entries) == 0 {
+		delete(s.history, shortURL)
+		return nil
+	}
+	history := make([]HistoryEntry, len(entries))
+	for i, entry := range entries {
+		entry.ShortURL = shortURL
+		history[i] = entry
+	}
+	s.history[shortURL] = history
+	return nil
+}
+
+func (s *InMemoryStore) SaveAPIKey(ctx context.Context, key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apiKeys[key.Hash] = key
+	return nil
+}
+
+func (s *InMemoryStore) GetAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.apiKeys[hash]
+	if !ok {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func (s *InMemoryStore) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	s.mu.RLock()
+	var keys []APIKey
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+func (s *InMemoryStore) AllAPIKeys(ctx context.Context) ([]APIKey, error) {
+	s.mu.RLock()
+	keys := make([]APIKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		keys = append(keys, key)
+	}
+	s.mu.RUnlock()
+
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+func (s *InMemoryStore) DeleteAPIKey(ctx context.Context, id, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, key := range s.apiKeys {
+		if key.ID == id && key.UserID == userID {
+			delete(s.apiKeys, hash)
 			return nil
 		}
-		return err
 	}
-	defer file.Close()
+	return ErrAPIKeyNotFound
+}
 
+func sortAPIKeys(keys []APIKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
+
+func (s *InMemoryStore) List(ctx context.Context, filter ListFilter) ([]URLData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var page []URLData
+	for i := sort.SearchStrings(s.keys, filter.After); i < len(s.keys); i++ {
+		urlData := s.data[s.keys[i]]
+		if !filter.match(urlData) {
+			continue
+		}
+		page = append(page, urlData)
+		if filter.Limit > 0 && len(page) == filter.Limit {
+			break
+		}
+	}
+	return page, nil
+}
+
+// HistoryFilePath возвращает путь к файлу, в котором InMemoryStore хранит
+// историю изменений ссылок рядом с файлом ссылок filePath.
+func HistoryFilePath(filePath string) string {
+	return filePath + ".history"
+}
+
+// APIKeysFilePath возвращает путь к файлу, в котором InMemoryStore хранит
+// ключи доступа рядом с файлом ссылок filePath.
+func APIKeysFilePath(filePath string) string {
+	return filePath + ".keys"
+}
+
+func (s *InMemoryStore) LoadFromFile(filePath string) error {
 	var urlDataList []URLData
-	if err := json.NewDecoder(file).Decode(&urlDataList); err != nil {
+	if err := readJSONFile(filePath, &urlDataList); err != nil {
+		return err
+	}
+	var history []HistoryEntry
+	if err := readJSONFile(HistoryFilePath(filePath), &history); err != nil {
+		return err
+	}
+	var apiKeys []APIKey
+	if err := readJSONFile(APIKeysFilePath(filePath), &apiKeys); err != nil {
 		return err
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// в старых файлах один URL мог сохраниться под несколькими ключами;
+	// все ключи продолжают работать, а в индекс попадает первый
 	for _, urlData := range urlDataList {
-		s.data[urlData.ShortURL] = urlData
+		s.put(urlData)
+	}
+	for _, entry := range history {
+		s.history[entry.ShortURL] = append(s.history[entry.ShortURL], entry)
+	}
+	for _, key := range apiKeys {
+		s.apiKeys[key.Hash] = key
 	}
 
 	return nil
 }
 
 func (s *InMemoryStore) SaveToFile(filePath string) error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
 	s.mu.RLock()
 	var urlDataList []URLData
 	for _, urlData := range s.data {
 		urlDataList = append(urlDataList, urlData)
 	}
+	// записи каждой ссылки идут подряд в исходном порядке, поэтому
+	// LoadFromFile восстанавливает их последовательность
+	var history []HistoryEntry
+	for _, entries := range s.history {
+		history = append(history, entries...)
+	}
+	var apiKeys []APIKey
+	for _, key := range s.apiKeys {
+		apiKeys = append(apiKeys, key)
+	}
 	s.mu.RUnlock()
 
-	data, err := json.Marshal(urlDataList)
+	if err := writeJSONFile(filePath, urlDataList); err != nil {
+		return err
+	}
+	if err := writeJSONFile(HistoryFilePath(filePath), history); err != nil {
+		return err
+	}
+	sortAPIKeys(apiKeys)
+	return writeJSONFile(APIKeysFilePath(filePath), apiKeys)
+}
+
+// readJSONFile читает JSON из файла в v; отсутствующий файл — не ошибка.
+func readJSONFile(filePath string, v interface{}) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(v)
+}
+
+func writeJSONFile(filePath string, v interface{}) error {
+	data, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
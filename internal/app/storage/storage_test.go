@@ -1,14 +1,30 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
 	"regexp"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/condratf/shortner/internal/app/models"
+	"github.com/condratf/shortner/internal/app/logger"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestPostgresStore_SaveBatch(t *testing.T) {
@@ -20,50 +36,1047 @@ func TestPostgresStore_SaveBatch(t *testing.T) {
 
 	store := &PostgresStore{db: db}
 
-	items := []models.BatchItem{
-		{CorrelationID: uuid.New().String(), ShortURL: "short1", OriginalURL: "http://example.com/1"},
-		{CorrelationID: uuid.New().String(), ShortURL: "short2", OriginalURL: "http://example.com/2"},
+	items := []URLData{
+		{UUID: uuid.New().String(), ShortURL: "short1", OriginalURL: "http://example.com/1"},
+		{UUID: uuid.New().String(), ShortURL: "short2", OriginalURL: "http://example.com/2"},
 	}
 
-	query := `
-			INSERT INTO urls (id, short_url, original_url)
-			VALUES ($1, $2, $3)
-			ON CONFLICT (original_url) DO NOTHING
-			RETURNING id, short_url
-	`
+	copyQuery := pq.CopyIn(batchTable, batchColumns...)
 
-	mock.ExpectBegin()
-
-	for _, item := range items {
-		mock.ExpectQuery(regexp.QuoteMeta(query)).
-			WithArgs(item.CorrelationID, item.ShortURL, item.OriginalURL).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "short_url"}).AddRow(item.CorrelationID, item.ShortURL))
+	expectBatchCopy := func(items []URLData) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(createBatchTableQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(regexp.QuoteMeta(copyQuery))
+		for i, item := range items {
+			mock.ExpectExec(regexp.QuoteMeta(copyQuery)).
+				WithArgs(item.UUID, item.ShortURL, item.OriginalURL, item.UserID, sqlmock.AnyArg(), false, "", nil, 0, nil, "", 0, int64(0), nil, nil, false, i).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectExec(regexp.QuoteMeta(copyQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
 	}
 
+	expectBatchCopy(items)
+	mock.ExpectQuery(regexp.QuoteMeta(insertBatchQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow(items[0].ShortURL).AddRow(items[1].ShortURL))
 	mock.ExpectCommit()
 
-	urlDataList, err := store.SaveBatch(items)
+	urlDataList, err := store.SaveBatch(context.Background(), items)
 	assert.NoError(t, err, "Expected no error during SaveBatch")
 	assert.Len(t, urlDataList, len(items), "Expected urlDataList to have the same length as input items")
 
 	for i, item := range items {
-		assert.Equal(t, item.CorrelationID, urlDataList[i].UUID)
+		assert.Equal(t, item.UUID, urlDataList[i].UUID)
 		assert.Equal(t, item.ShortURL, urlDataList[i].ShortURL)
 		assert.Equal(t, item.OriginalURL, urlDataList[i].OriginalURL)
 	}
 
 	// Case: Transaction fails to begin
 	mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
-	_, err = store.SaveBatch(items)
+	_, err = store.SaveBatch(context.Background(), items)
 	assert.Error(t, err, "Expected error when transaction fails to begin")
 
-	// Case: Query fails
-	mock.ExpectBegin()
+	// Case: Insert fails
+	expectBatchCopy(items)
+	mock.ExpectQuery(regexp.QuoteMeta(insertBatchQuery)).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	_, err = store.SaveBatch(context.Background(), items)
+	assert.Error(t, err, "Expected error when query fails")
+
+	// Case: Short URL is already taken
+	expectBatchCopy(items)
+	mock.ExpectQuery(regexp.QuoteMeta(insertBatchQuery)).WillReturnError(&pq.Error{
+		Code:       uniqueViolation,
+		Constraint: shortURLConstraint,
+		Detail:     "Key (short_url)=(short1) already exists.",
+	})
+	mock.ExpectRollback()
+
+	_, err = store.SaveBatch(context.Background(), items)
+	assert.ErrorIs(t, err, ErrShortURLTaken)
+
+	// Case: Original URL already exists
+	expectBatchCopy(items)
+	mock.ExpectQuery(regexp.QuoteMeta(insertBatchQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow(items[0].ShortURL))
+	mock.ExpectQuery(regexp.QuoteMeta(batchConflictsQuery)).
+		WithArgs(pq.Array([]string{items[1].OriginalURL})).
+		WillReturnRows(sqlmock.NewRows([]string{"original_url", "short_url"}).AddRow(items[1].OriginalURL, "existing"))
+	mock.ExpectRollback()
+
+	_, err = store.SaveBatch(context.Background(), items)
+	var existsErr *ErrURLExists
+	assert.ErrorAs(t, err, &existsErr, "Expected ErrURLExists when original URL is stored")
+	assert.Equal(t, items[1].UUID, existsErr.ID)
+	assert.Equal(t, "existing", existsErr.ExistingShortURL)
+	assert.Equal(t, []URLData{{UUID: items[1].UUID, ShortURL: "existing", OriginalURL: items[1].OriginalURL}}, existsErr.Conflicts)
+
+	// Case: Rows are skipped without an original URL conflict
+	repeated := []URLData{items[0], {UUID: uuid.New().String(), ShortURL: items[0].ShortURL, OriginalURL: "http://example.com/3"}}
+	expectBatchCopy(repeated)
+	mock.ExpectQuery(regexp.QuoteMeta(insertBatchQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow(items[0].ShortURL))
+	mock.ExpectRollback()
+
+	_, err = store.SaveBatch(context.Background(), repeated)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &existsErr))
+
+	// Case: Original URL repeats within the batch
+	inBatch := []URLData{
+		items[0],
+		{UUID: uuid.New().String(), ShortURL: "short3", OriginalURL: items[0].OriginalURL},
+		{UUID: uuid.New().String(), ShortURL: "short4", OriginalURL: items[1].OriginalURL},
+	}
+	expectBatchCopy(inBatch)
+	mock.ExpectQuery(regexp.QuoteMeta(insertBatchQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow(items[0].ShortURL))
+	// в хранилище ищется только исходный URL, не вставленный этой же пачкой
+	mock.ExpectQuery(regexp.QuoteMeta(batchConflictsQuery)).
+		WithArgs(pq.Array([]string{items[1].OriginalURL})).
+		WillReturnRows(sqlmock.NewRows([]string{"original_url", "short_url"}).AddRow(items[1].OriginalURL, "existing"))
+	mock.ExpectRollback()
+
+	_, err = store.SaveBatch(context.Background(), inBatch)
+	assert.ErrorAs(t, err, &existsErr)
+	assert.Equal(t, []URLData{
+		{UUID: inBatch[1].UUID, ShortURL: items[0].ShortURL, OriginalURL: items[0].OriginalURL},
+		{UUID: inBatch[2].UUID, ShortURL: "existing", OriginalURL: items[1].OriginalURL},
+	}, existsErr.Conflicts)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// perRowInsertQuery — прежний способ сохранения пачки: по INSERT на строку.
+const perRowInsertQuery = `
+    INSERT INTO urls (id, short_url, original_url, user_id, created_at, allow_duplicate, tag, metadata, redirect_type, expires_at, password_hash, max_clicks, clicks, page_meta, health, disabled)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+    ON CONFLICT (original_url) WHERE NOT allow_duplicate DO NOTHING
+    RETURNING id, short_url
+  `
+
+// saveBatchPerRow сохраняет пачку прежним способом и служит точкой отсчёта
+// для BenchmarkPostgresStore_SaveBatch.
+func saveBatchPerRow(ctx context.Context, db *sql.DB, items []URLData) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, item := range items {
+		metadata, err := encodeMetadata(item.Metadata)
+		if err != nil {
+			return err
+		}
+		pageMeta, err := encodeJSONB(item.PageMeta)
+		if err != nil {
+			return err
+		}
+		health, err := encodeJSONB(item.Health)
+		if err != nil {
+			return err
+		}
+		var id, shortURL string
+		err = tx.QueryRowContext(ctx, perRowInsertQuery,
+			item.UUID, item.ShortURL, item.OriginalURL, item.UserID, item.CreatedAt,
+			item.AllowDuplicate, item.Tag, metadata, item.RedirectType, item.ExpiresAt, item.PasswordHash,
+			item.MaxClicks, item.Clicks, pageMeta, health, item.Disabled,
+		).Scan(&id, &shortURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func BenchmarkPostgresStore_SaveBatchPerRow(b *testing.B) {
+	for _, size := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("items=%d", size), func(b *testing.B) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				b.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			items := make([]URLData, size)
+			for i := range items {
+				items[i] = URLData{
+					UUID:        uuid.New().String(),
+					ShortURL:    fmt.Sprintf("short%d", i),
+					OriginalURL: fmt.Sprintf("http://example.com/%d", i),
+				}
+			}
+
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				mock.ExpectBegin()
+				for _, item := range items {
+					mock.ExpectQuery(regexp.QuoteMeta(perRowInsertQuery)).
+						WillReturnRows(sqlmock.NewRows([]string{"id", "short_url"}).AddRow(item.UUID, item.ShortURL))
+				}
+				mock.ExpectCommit()
+				b.StartTimer()
+
+				if err := saveBatchPerRow(context.Background(), db, items); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPostgresStore_SaveBatch(b *testing.B) {
+	copyQuery := pq.CopyIn(batchTable, batchColumns...)
+
+	for _, size := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("items=%d", size), func(b *testing.B) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				b.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			store := &PostgresStore{db: db}
+
+			items := make([]URLData, size)
+			for i := range items {
+				items[i] = URLData{
+					UUID:        uuid.New().String(),
+					ShortURL:    fmt.Sprintf("short%d", i),
+					OriginalURL: fmt.Sprintf("http://example.com/%d", i),
+				}
+			}
+
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				inserted := sqlmock.NewRows([]string{"short_url"})
+				for _, item := range items {
+					inserted.AddRow(item.ShortURL)
+				}
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(createBatchTableQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectPrepare(regexp.QuoteMeta(copyQuery))
+				for range items {
+					mock.ExpectExec(regexp.QuoteMeta(copyQuery)).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectExec(regexp.QuoteMeta(copyQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta(insertBatchQuery)).WillReturnRows(inserted)
+				mock.ExpectCommit()
+				b.StartTimer()
+
+				if _, err := store.SaveBatch(context.Background(), items); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestInMemoryStore_List(t *testing.T) {
+	store := NewInMemoryStore()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		_, err := store.Save(context.Background(), URLData{
+			ShortURL:    fmt.Sprintf("key%d", i),
+			OriginalURL: fmt.Sprintf("http://example.com/%d", i),
+			UserID:      fmt.Sprintf("user%d", i%2),
+			CreatedAt:   created.AddDate(0, 0, i),
+		})
+		assert.NoError(t, err)
+	}
+
+	var keys []string
+	err := Iterate(context.Background(), store, ListFilter{Limit: 2}, func(data URLData) error {
+		keys = append(keys, data.ShortURL)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key0", "key1", "key2", "key3", "key4"}, keys)
+
+	page, err := store.List(context.Background(), ListFilter{UserID: "user0", After: "key0"})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Equal(t, "key2", page[0].ShortURL)
+	assert.Equal(t, "key4", page[1].ShortURL)
+
+	page, err = store.List(context.Background(), ListFilter{From: created.AddDate(0, 0, 1), To: created.AddDate(0, 0, 3)})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Equal(t, "key1", page[0].ShortURL)
+	assert.Equal(t, "key2", page[1].ShortURL)
+
+	// Case: Index of keys follows inserts, updates and deletes
+	assert.NoError(t, store.Delete(context.Background(), "key2"))
+	_, err = store.Save(context.Background(), URLData{ShortURL: "key1a", OriginalURL: "http://example.com/1a", UserID: "user1"})
+	assert.NoError(t, err)
+	newURL := "http://example.com/3b"
+	_, err = store.Update(context.Background(), "key3", "user1", URLUpdate{OriginalURL: &newURL})
+	assert.NoError(t, err)
+
+	keys = nil
+	err = Iterate(context.Background(), store, ListFilter{Limit: 2}, func(data URLData) error {
+		keys = append(keys, data.ShortURL)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key0", "key1", "key1a", "key3", "key4"}, keys)
+}
+
+func TestPostgresStore_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := &PostgresStore{db: db}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	query := `SELECT id, short_url, original_url, user_id, created_at, allow_duplicate, tag, metadata, redirect_type, expires_at, password_hash, max_clicks, clicks, page_meta, health, disabled FROM urls WHERE short_url > $1 AND user_id = $2 AND original_url ILIKE $3 AND created_at >= $4 ORDER BY short_url LIMIT $5`
 	mock.ExpectQuery(regexp.QuoteMeta(query)).
-		WithArgs(items[0].CorrelationID, items[0].ShortURL, items[0].OriginalURL).
+		WithArgs("key0", "user", `%example.com/\%\_%`, from, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "short_url", "original_url", "user_id", "created_at", "allow_duplicate", "tag", "metadata", "redirect_type", "expires_at", "password_hash", "max_clicks", "clicks", "page_meta", "health", "disabled"}).
+			AddRow("uuid-1", "key1", "http://example.com/1", "user", from, false, "", nil, 0, nil, "", 0, 0, nil, nil, false).
+			AddRow("uuid-2", "key2", "http://example.com/2", "user", from, true, "spring", []byte(`{"channel":"email"}`), 301, from, "hash", 10, 3,
+				[]byte(`{"title":"Example","fetched_at":"2024-01-01T00:00:00Z"}`),
+				[]byte(`{"status":404,"latency_ms":12,"checked_at":"2024-01-01T00:00:00Z"}`), true))
+
+	page, err := store.List(context.Background(), ListFilter{UserID: "user", OriginalContains: "example.com/%_", From: from, After: "key0", Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []URLData{
+		{UUID: "uuid-1", ShortURL: "key1", OriginalURL: "http://example.com/1", UserID: "user", CreatedAt: from},
+		{
+			UUID: "uuid-2", ShortURL: "key2", OriginalURL: "http://example.com/2", UserID: "user", CreatedAt: from,
+			AllowDuplicate: true, Tag: "spring", Metadata: map[string]string{"channel": "email"},
+			RedirectType: 301, ExpiresAt: &from, PasswordHash: "hash", MaxClicks: 10, Clicks: 3,
+			PageMeta: &PageMeta{Title: "Example", FetchedAt: from},
+			Health:   &LinkHealth{Status: 404, LatencyMS: 12, CheckedAt: from},
+			Disabled: true,
+		},
+	}, page)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shortener.db")
+	store, err := NewBoltStore(path)
+	assert.NoError(t, err)
+
+	id, err := store.Save(context.Background(), URLData{ShortURL: "key1", OriginalURL: "http://example.com/1", UserID: "user"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, id)
+
+	data, err := store.Get(context.Background(), "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/1", data.OriginalURL)
+
+	_, err = store.Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Case: Original URL already exists
+	_, err = store.Save(context.Background(), URLData{ShortURL: "key2", OriginalURL: "http://example.com/1"})
+	var existsErr *ErrURLExists
+	assert.ErrorAs(t, err, &existsErr)
+	assert.Equal(t, "key1", existsErr.ExistingShortURL)
+
+	// Case: Batch with a stored original URL is rejected as a whole
+	items := []URLData{
+		{UUID: uuid.New().String(), ShortURL: "key3", OriginalURL: "http://example.com/3"},
+		{UUID: uuid.New().String(), ShortURL: "key4", OriginalURL: "http://example.com/1"},
+	}
+	_, err = store.SaveBatch(context.Background(), items)
+	assert.ErrorAs(t, err, &existsErr)
+	assert.Equal(t, items[1].UUID, existsErr.ID)
+	assert.Equal(t, []URLData{{UUID: items[1].UUID, ShortURL: "key1", OriginalURL: "http://example.com/1"}}, existsErr.Conflicts)
+	_, err = store.Get(context.Background(), "key3")
+	assert.Error(t, err)
+
+	saved, err := store.SaveBatch(context.Background(), items[:1])
+	assert.NoError(t, err)
+	assert.Len(t, saved, 1)
+
+	assert.NoError(t, store.(io.Closer).Close())
+
+	// данные переживают повторное открытие
+	store, err = NewBoltStore(path)
+	assert.NoError(t, err)
+	defer store.(io.Closer).Close()
+
+	page, err := store.List(context.Background(), ListFilter{After: "key1", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, "key3", page[0].ShortURL)
+	assert.Equal(t, items[0].UUID, page[0].UUID)
+
+	page, err = store.List(context.Background(), ListFilter{UserID: "user"})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, "key1", page[0].ShortURL)
+}
+
+func TestInMemoryStore_DuplicateOriginal(t *testing.T) {
+	store := NewInMemoryStore()
+
+	_, err := store.Save(context.Background(), URLData{ShortURL: "key1", OriginalURL: "http://example.com/1"})
+	assert.NoError(t, err)
+
+	_, err = store.Save(context.Background(), URLData{ShortURL: "key2", OriginalURL: "http://example.com/1"})
+	var existsErr *ErrURLExists
+	assert.ErrorAs(t, err, &existsErr)
+	assert.Equal(t, "key1", existsErr.ExistingShortURL)
+	_, err = store.Get(context.Background(), "key2")
+	assert.Error(t, err, "duplicate must not get a second key")
+
+	// Case: Batch with stored and repeated original URLs is rejected as a whole
+	items := []URLData{
+		{UUID: "id3", ShortURL: "key3", OriginalURL: "http://example.com/3"},
+		{UUID: "id4", ShortURL: "key4", OriginalURL: "http://example.com/1"},
+		{UUID: "id5", ShortURL: "key5", OriginalURL: "http://example.com/3"},
+	}
+	_, err = store.SaveBatch(context.Background(), items)
+	assert.ErrorAs(t, err, &existsErr)
+	assert.Equal(t, "id4", existsErr.ID)
+	assert.Equal(t, "key1", existsErr.ExistingShortURL)
+	assert.Equal(t, []URLData{
+		{UUID: "id4", ShortURL: "key1", OriginalURL: "http://example.com/1"},
+		{UUID: "id5", ShortURL: "key3", OriginalURL: "http://example.com/3"},
+	}, existsErr.Conflicts)
+	_, err = store.Get(context.Background(), "key3")
+	assert.Error(t, err)
+
+	// Case: Reverse index is rebuilt from file
+	path := filepath.Join(t.TempDir(), "shortener.json")
+	assert.NoError(t, store.SaveToFile(path))
+
+	loaded := NewInMemoryStore()
+	assert.NoError(t, loaded.LoadFromFile(path))
+	_, err = loaded.Save(context.Background(), URLData{ShortURL: "key6", OriginalURL: "http://example.com/1"})
+	assert.ErrorAs(t, err, &existsErr)
+	assert.Equal(t, "key1", existsErr.ExistingShortURL)
+}
+
+// forEachStore запускает f подтестом для каждого встроенного хранилища,
+// каждый раз с новым пустым хранилищем.
+func forEachStore(t *testing.T, f func(t *testing.T, store Storage)) {
+	t.Run("memory", func(t *testing.T) {
+		f(t, NewInMemoryStore())
+	})
+	t.Run("bolt", func(t *testing.T) {
+		store, err := NewBoltStore(filepath.Join(t.TempDir(), "shortener.db"))
+		require.NoError(t, err)
+		defer store.(io.Closer).Close()
+		f(t, store)
+	})
+}
+
+func TestStore_SaveBatchShortURLTaken(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Storage) {
+		_, err := store.Save(context.Background(), URLData{UUID: "id1", ShortURL: "key1", OriginalURL: "http://example.com/1"})
+		assert.NoError(t, err)
+
+		// Case: Key of a stored link is not overwritten
+		_, err = store.SaveBatch(context.Background(), []URLData{
+			{UUID: "id2", ShortURL: "key2", OriginalURL: "http://example.com/2"},
+			{UUID: "id3", ShortURL: "key1", OriginalURL: "http://example.com/3"},
+		})
+		assert.ErrorIs(t, err, ErrShortURLTaken)
+
+		data, err := store.Get(context.Background(), "key1")
+		assert.NoError(t, err)
+		assert.Equal(t, "http://example.com/1", data.OriginalURL)
+		_, err = store.Get(context.Background(), "key2")
+		assert.ErrorIs(t, err, ErrNotFound, "batch with a taken key is rejected as a whole")
+
+		// Case: Key repeated within the batch
+		_, err = store.SaveBatch(context.Background(), []URLData{
+			{UUID: "id4", ShortURL: "key4", OriginalURL: "http://example.com/4"},
+			{UUID: "id5", ShortURL: "key4", OriginalURL: "http://example.com/5"},
+		})
+		assert.ErrorIs(t, err, ErrShortURLTaken)
+		_, err = store.Get(context.Background(), "key4")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestStore_AllowDuplicate(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Storage) {
+		_, err := store.Save(context.Background(), URLData{ShortURL: "key1", OriginalURL: "http://example.com/1"})
+		assert.NoError(t, err)
+
+		_, err = store.Save(context.Background(), URLData{
+			ShortURL:       "key2",
+			OriginalURL:    "http://example.com/1",
+			AllowDuplicate: true,
+			Tag:            "spring",
+			Metadata:       map[string]string{"channel": "email"},
+		})
+		assert.NoError(t, err)
+
+		// обычная ссылка по-прежнему указывает на первый ключ
+		_, err = store.Save(context.Background(), URLData{ShortURL: "key3", OriginalURL: "http://example.com/1"})
+		var existsErr *ErrURLExists
+		assert.ErrorAs(t, err, &existsErr)
+		assert.Equal(t, "key1", existsErr.ExistingShortURL)
+
+		page, err := store.List(context.Background(), ListFilter{After: "key1"})
+		assert.NoError(t, err)
+		assert.Len(t, page, 1)
+		assert.Equal(t, "spring", page[0].Tag)
+		assert.Equal(t, map[string]string{"channel": "email"}, page[0].Metadata)
+	})
+}
+
+func TestStore_Update(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Storage) {
+		_, err := store.Save(context.Background(), URLData{ShortURL: "key1", OriginalURL: "http://example.com/1", UserID: "user"})
+		assert.NoError(t, err)
+		_, err = store.Save(context.Background(), URLData{ShortURL: "key2", OriginalURL: "http://example.com/2", UserID: "user"})
+		assert.NoError(t, err)
+		assert.NoError(t, store.SetPageMeta(context.Background(), "key1", PageMeta{Title: "Old"}))
+		assert.NoError(t, store.SetHealth(context.Background(), "key1", LinkHealth{Status: 404}))
+
+		newURL := "http://example.com/new"
+		_, err = store.Update(context.Background(), "missing", "user", URLUpdate{OriginalURL: &newURL})
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = store.Update(context.Background(), "key1", "other", URLUpdate{OriginalURL: &newURL})
+		assert.ErrorIs(t, err, ErrForbidden)
+
+		// Case: New destination is already shortened
+		taken := "http://example.com/2"
+		_, err = store.Update(context.Background(), "key1", "user", URLUpdate{OriginalURL: &taken})
+		var existsErr *ErrURLExists
+		assert.ErrorAs(t, err, &existsErr)
+		assert.Equal(t, "key2", existsErr.ExistingShortURL)
+
+		redirectType := 301
+		expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		updated, err := store.Update(context.Background(), "key1", "user", URLUpdate{
+			OriginalURL:  &newURL,
+			RedirectType: &redirectType,
+			ExpiresAt:    &expiresAt,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, newURL, updated.OriginalURL)
+
+		data, err := store.Get(context.Background(), "key1")
+		assert.NoError(t, err)
+		assert.Equal(t, newURL, data.OriginalURL)
+		assert.Equal(t, 301, data.RedirectType)
+		assert.True(t, expiresAt.Equal(*data.ExpiresAt))
+		// превью и проверка прежнего адреса сбрасываются
+		assert.Nil(t, data.PageMeta)
+		assert.Nil(t, data.Health)
+
+		_, err = store.Update(context.Background(), "key1", "user", URLUpdate{ClearExpiry: true})
+		assert.NoError(t, err)
+		data, err = store.Get(context.Background(), "key1")
+		assert.NoError(t, err)
+		assert.Nil(t, data.ExpiresAt)
+
+		// старый адрес освобождается, новый занят изменённой ссылкой
+		_, err = store.Save(context.Background(), URLData{ShortURL: "key3", OriginalURL: "http://example.com/1"})
+		assert.NoError(t, err)
+		_, err = store.Save(context.Background(), URLData{ShortURL: "key4", OriginalURL: newURL})
+		assert.ErrorAs(t, err, &existsErr)
+		assert.Equal(t, "key1", existsErr.ExistingShortURL)
+
+		history, err := store.History(context.Background(), "key1")
+		assert.NoError(t, err)
+		assert.Len(t, history, 2)
+		assert.Equal(t, "http://example.com/1", history[0].OriginalURL)
+		assert.Equal(t, "user", history[0].ChangedBy)
+		assert.Equal(t, 301, history[1].RedirectType)
+		assert.NotNil(t, history[1].ExpiresAt)
+
+		// Case: History is replaced, not appended
+		assert.NoError(t, store.SetHistory(context.Background(), "key2", history))
+		assert.NoError(t, store.SetHistory(context.Background(), "key2", history[:1]))
+		replaced, err := store.History(context.Background(), "key2")
+		assert.NoError(t, err)
+		assert.Len(t, replaced, 1)
+		assert.Equal(t, "key2", replaced[0].ShortURL)
+		assert.Equal(t, history[0].OriginalURL, replaced[0].OriginalURL)
+	})
+}
+
+func TestPostgresStore_Update(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := &PostgresStore{db: db}
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"id", "short_url", "original_url", "user_id", "created_at", "allow_duplicate", "tag", "metadata", "redirect_type", "expires_at", "password_hash", "max_clicks", "clicks", "page_meta", "health", "disabled"}
+	newURL := "http://example.com/new"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectForUpdateQuery)).
+		WithArgs("key1").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("uuid-1", "key1", "http://example.com/1", "user", createdAt, false, "", nil, 0, nil, "", 0, 0, nil, nil, false))
+	mock.ExpectQuery(regexp.QuoteMeta(originalConflictQuery)).
+		WithArgs(newURL, "key1").
 		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(regexp.QuoteMeta(insertHistoryQuery)).
+		WithArgs("key1", "http://example.com/1", 0, nil, "user", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(updateURLQuery)).
+		WithArgs(newURL, 0, nil, nil, nil, "key1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	updated, err := store.Update(context.Background(), "key1", "user", URLUpdate{OriginalURL: &newURL})
+	assert.NoError(t, err)
+	assert.Equal(t, newURL, updated.OriginalURL)
+
+	// Case: Link belongs to another user
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectForUpdateQuery)).
+		WithArgs("key1").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("uuid-1", "key1", newURL, "user", createdAt, false, "", nil, 0, nil, "", 0, 0, nil, nil, false))
 	mock.ExpectRollback()
 
-	_, err = store.SaveBatch(items)
-	assert.Error(t, err, "Expected error when query fails")
+	_, err = store.Update(context.Background(), "key1", "other", URLUpdate{OriginalURL: &newURL})
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	// Case: Unknown key
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectForUpdateQuery)).
+		WithArgs("missing").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, err = store.Update(context.Background(), "missing", "user", URLUpdate{OriginalURL: &newURL})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Case: History is replaced within one transaction
+	changedAt := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(deleteHistoryQuery)).WithArgs("key1").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(insertHistoryQuery)).
+		WithArgs("key1", "http://example.com/1", 0, nil, "user", changedAt).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = store.SetHistory(context.Background(), "key1", []HistoryEntry{
+		{ShortURL: "key1", OriginalURL: "http://example.com/1", ChangedBy: "user", ChangedAt: changedAt},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStore_Click(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Storage) {
+		_, err := store.Save(context.Background(), URLData{ShortURL: "once", OriginalURL: "http://example.com/1", MaxClicks: 3})
+		assert.NoError(t, err)
+		_, err = store.Save(context.Background(), URLData{ShortURL: "open", OriginalURL: "http://example.com/2"})
+		assert.NoError(t, err)
+
+		_, err = store.Click(context.Background(), "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = store.Get(context.Background(), "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		succeeded, limited := 0, 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := store.Click(context.Background(), "once")
+				mu.Lock()
+				defer mu.Unlock()
+				if errors.Is(err, ErrClickLimitReached) {
+					limited++
+				} else if assert.NoError(t, err) {
+					succeeded++
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 3, succeeded)
+		assert.Equal(t, 17, limited)
+
+		data, err := store.Get(context.Background(), "once")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), data.Clicks)
+
+		data, err = store.Click(context.Background(), "open")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), data.Clicks)
+	})
+}
+
+func TestPostgresStore_Click(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := &PostgresStore{db: db}
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"id", "short_url", "original_url", "user_id", "created_at", "allow_duplicate", "tag", "metadata", "redirect_type", "expires_at", "password_hash", "max_clicks", "clicks", "page_meta", "health", "disabled"}
+
+	mock.ExpectQuery(regexp.QuoteMeta(clickQuery)).
+		WithArgs("once").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("uuid-1", "once", "http://example.com/1", "", createdAt, false, "", nil, 0, nil, "", 1, 1, nil, nil, false))
+
+	data, err := store.Click(context.Background(), "once")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), data.Clicks)
+
+	// Case: Guard in UPDATE filtered the row out
+	mock.ExpectQuery(regexp.QuoteMeta(clickQuery)).WithArgs("once").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(urlExistsQuery)).WithArgs("once").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	_, err = store.Click(context.Background(), "once")
+	assert.ErrorIs(t, err, ErrClickLimitReached)
+
+	// Case: Unknown key
+	mock.ExpectQuery(regexp.QuoteMeta(clickQuery)).WithArgs("missing").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(urlExistsQuery)).WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	_, err = store.Click(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_Get(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := &PostgresStore{db: db}
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE short_url = $1`
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs("missing").WillReturnError(sql.ErrNoRows)
+	_, err = store.Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Case: Database failure is not reported as an unknown key
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs("key1").WillReturnError(sql.ErrConnDone)
+	_, err = store.Get(context.Background(), "key1")
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.NotErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_Save(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := &PostgresStore{db: db}
+	data := URLData{ShortURL: "key1", OriginalURL: "http://example.com"}
+	insert := regexp.QuoteMeta("INSERT INTO urls")
+	existing := regexp.QuoteMeta(`SELECT short_url FROM urls WHERE original_url = $1 AND NOT allow_duplicate`)
+
+	mock.ExpectQuery(insert).WillReturnRows(sqlmock.NewRows([]string{"id", "short_url"}).AddRow("id1", "key1"))
+	id, err := store.Save(context.Background(), data)
+	assert.NoError(t, err)
+	assert.Equal(t, "id1", id)
+
+	// Case: Original URL already exists, so the insert returns no rows
+	mock.ExpectQuery(insert).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(existing).WithArgs(data.OriginalURL).WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow("old"))
+	_, err = store.Save(context.Background(), data)
+	var existsErr *ErrURLExists
+	assert.ErrorAs(t, err, &existsErr)
+	assert.Equal(t, "old", existsErr.ExistingShortURL)
+
+	// Case: Database failure is not reported as a duplicate
+	mock.ExpectQuery(insert).WillReturnError(sql.ErrConnDone)
+	_, err = store.Save(context.Background(), data)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.False(t, errors.As(err, &existsErr))
+
+	// Case: Short URL collision is not reported as a duplicate original URL
+	mock.ExpectQuery(insert).WillReturnError(&pq.Error{Code: "23505", Constraint: "urls_short_url_key"})
+	_, err = store.Save(context.Background(), data)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &existsErr))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStore_SetPageMetaAndHealth(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Storage) {
+		_, err := store.Save(context.Background(), URLData{ShortURL: "key1", OriginalURL: "http://example.com/1"})
+		assert.NoError(t, err)
+
+		meta := PageMeta{Title: "Example", Favicon: "http://example.com/favicon.ico", FetchedAt: time.Now().UTC().Truncate(time.Second)}
+		assert.NoError(t, store.SetPageMeta(context.Background(), "key1", meta))
+		assert.ErrorIs(t, store.SetPageMeta(context.Background(), "missing", meta), ErrNotFound)
+
+		health := LinkHealth{Status: 404, LatencyMS: 30, CheckedAt: meta.FetchedAt}
+		assert.NoError(t, store.SetHealth(context.Background(), "key1", health))
+		assert.ErrorIs(t, store.SetHealth(context.Background(), "missing", health), ErrNotFound)
+
+		data, err := store.Get(context.Background(), "key1")
+		assert.NoError(t, err)
+		assert.Equal(t, &meta, data.PageMeta)
+		assert.Equal(t, &health, data.Health)
+		assert.True(t, data.Health.Broken())
+	})
+}
+
+func TestStore_APIKeys(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := APIKey{ID: "id-1", UserID: "user0", Prefix: "sk_1", Hash: "hash-1", Scopes: []string{"shorten"}, CreatedAt: createdAt}
+	second := APIKey{ID: "id-2", UserID: "user0", Name: "ci", Prefix: "sk_2", Hash: "hash-2", Scopes: []string{"shorten", "read"}, CreatedAt: createdAt.Add(time.Hour)}
+	foreign := APIKey{ID: "id-3", UserID: "user1", Prefix: "sk_3", Hash: "hash-3", Scopes: []string{"read"}, CreatedAt: createdAt}
+
+	forEachStore(t, func(t *testing.T, store Storage) {
+		for _, key := range []APIKey{second, foreign, first} {
+			assert.NoError(t, store.SaveAPIKey(context.Background(), key))
+		}
+
+		key, err := store.GetAPIKey(context.Background(), "hash-2")
+		assert.NoError(t, err)
+		assert.Equal(t, second, key)
+		_, err = store.GetAPIKey(context.Background(), "unknown")
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+
+		keys, err := store.ListAPIKeys(context.Background(), "user0")
+		assert.NoError(t, err)
+		assert.Equal(t, []APIKey{first, second}, keys)
+		keys, err = store.AllAPIKeys(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []APIKey{first, foreign, second}, keys)
+
+		assert.ErrorIs(t, store.DeleteAPIKey(context.Background(), "id-3", "user0"), ErrAPIKeyNotFound)
+		assert.NoError(t, store.DeleteAPIKey(context.Background(), "id-1", "user0"))
+		assert.ErrorIs(t, store.DeleteAPIKey(context.Background(), "id-1", "user0"), ErrAPIKeyNotFound)
+
+		_, err = store.GetAPIKey(context.Background(), "hash-1")
+		assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+		_, err = store.GetAPIKey(context.Background(), "hash-3")
+		assert.NoError(t, err)
+	})
+}
+
+func TestInMemoryStore_HistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shortener.json")
+	store := NewInMemoryStore()
+	_, err := store.Save(context.Background(), URLData{ShortURL: "key1", OriginalURL: "http://example.com/1", UserID: "user"})
+	assert.NoError(t, err)
+	for _, originalURL := range []string{"http://example.com/2", "http://example.com/3"} {
+		originalURL := originalURL
+		_, err = store.Update(context.Background(), "key1", "user", URLUpdate{OriginalURL: &originalURL})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, store.SaveToFile(path))
+	assert.FileExists(t, HistoryFilePath(path))
+
+	loaded := NewInMemoryStore()
+	assert.NoError(t, loaded.LoadFromFile(path))
+	want, err := store.History(context.Background(), "key1")
+	assert.NoError(t, err)
+	history, err := loaded.History(context.Background(), "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, want, history)
+	assert.Equal(t, "http://example.com/1", history[0].OriginalURL)
+	assert.Equal(t, "http://example.com/2", history[1].OriginalURL)
+}
+
+func TestInMemoryStore_APIKeysFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shortener.json")
+	key := APIKey{ID: "id-1", UserID: "user0", Prefix: "sk_1", Hash: "hash-1", Scopes: []string{"read"}, CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	store := NewInMemoryStore()
+	assert.NoError(t, store.SaveAPIKey(context.Background(), key))
+	assert.NoError(t, store.SaveToFile(path))
+	assert.FileExists(t, APIKeysFilePath(path))
+
+	loaded := NewInMemoryStore()
+	assert.NoError(t, loaded.LoadFromFile(path))
+	got, err := loaded.GetAPIKey(context.Background(), "hash-1")
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	// Case: Deleted key disappears from the file
+	assert.NoError(t, store.DeleteAPIKey(context.Background(), "id-1", "user0"))
+	assert.NoError(t, store.SaveToFile(path))
+	loaded = NewInMemoryStore()
+	assert.NoError(t, loaded.LoadFromFile(path))
+	_, err = loaded.GetAPIKey(context.Background(), "hash-1")
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
+
+func TestPostgresStore_APIKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := &PostgresStore{db: db}
+	id := "6f1c2d9e-8a63-4f0e-b4f5-1d2c3b4a5e6f"
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := APIKey{ID: id, UserID: "user0", Name: "ci", Prefix: "sk_1", Hash: "hash-1", Scopes: []string{"shorten", "read"}, CreatedAt: createdAt}
+
+	mock.ExpectExec(regexp.QuoteMeta(insertAPIKeyQuery)).
+		WithArgs(id, "user0", "ci", "sk_1", "hash-1", "{\"shorten\",\"read\"}", createdAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, store.SaveAPIKey(context.Background(), key))
+
+	columns := []string{"id", "user_id", "name", "prefix", "hash", "scopes", "created_at"}
+	mock.ExpectQuery(regexp.QuoteMeta(getAPIKeyQuery)).WithArgs("hash-1").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "user0", "ci", "sk_1", "hash-1", "{shorten,read}", createdAt))
+	got, err := store.GetAPIKey(context.Background(), "hash-1")
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getAPIKeyQuery)).WithArgs("unknown").WillReturnError(sql.ErrNoRows)
+	_, err = store.GetAPIKey(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+
+	mock.ExpectQuery(regexp.QuoteMeta(allAPIKeysQuery)).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "user0", "ci", "sk_1", "hash-1", "{shorten,read}", createdAt))
+	keys, err := store.AllAPIKeys(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []APIKey{key}, keys)
+
+	mock.ExpectExec(regexp.QuoteMeta(deleteAPIKeyQuery)).WithArgs(id, "user1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, store.DeleteAPIKey(context.Background(), id, "user1"), ErrAPIKeyNotFound)
+
+	// строка не в формате UUID не доходит до базы
+	assert.ErrorIs(t, store.DeleteAPIKey(context.Background(), "not-a-uuid", "user0"), ErrAPIKeyNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStore_Moderation(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Storage) {
+		_, err := store.Save(context.Background(), URLData{ShortURL: "key1", OriginalURL: "http://Example.com/Casino", UserID: "user0"})
+		assert.NoError(t, err)
+		_, err = store.Save(context.Background(), URLData{ShortURL: "key2", OriginalURL: "http://example.org/news", UserID: "user0"})
+		assert.NoError(t, err)
+
+		page, err := store.List(context.Background(), ListFilter{OriginalContains: "example.COM/casino"})
+		assert.NoError(t, err)
+		assert.Len(t, page, 1)
+		assert.Equal(t, "key1", page[0].ShortURL)
+
+		assert.NoError(t, store.SetDisabled(context.Background(), "key1", true))
+		assert.ErrorIs(t, store.SetDisabled(context.Background(), "missing", true), ErrNotFound)
+		data, err := store.Get(context.Background(), "key1")
+		assert.NoError(t, err)
+		assert.True(t, data.Disabled)
+
+		_, err = store.Update(context.Background(), "key1", "user0", URLUpdate{OriginalURL: &data.OriginalURL})
+		assert.NoError(t, err)
+		assert.NoError(t, store.Delete(context.Background(), "key1"))
+		assert.ErrorIs(t, store.Delete(context.Background(), "key1"), ErrNotFound)
+
+		_, err = store.Get(context.Background(), "key1")
+		assert.Error(t, err)
+		history, err := store.History(context.Background(), "key1")
+		assert.NoError(t, err)
+		assert.Empty(t, history)
+
+		// исходный URL удалённой ссылки можно сократить снова
+		_, err = store.Save(context.Background(), URLData{ShortURL: "key3", OriginalURL: "http://Example.com/Casino"})
+		assert.NoError(t, err)
+	})
+}
+
+func TestPostgresStore_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := &PostgresStore{db: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(deleteURLQuery)).WithArgs("key1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(deleteHistoryQuery)).WithArgs("key1").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	assert.NoError(t, store.Delete(context.Background(), "key1"))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(deleteURLQuery)).WithArgs("missing").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, store.Delete(context.Background(), "missing"), ErrNotFound)
+
+	mock.ExpectExec(regexp.QuoteMeta(setDisabledQuery)).WithArgs(true, "key2").WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, store.SetDisabled(context.Background(), "key2", true))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithLogging(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx := logger.WithContext(context.Background(), zap.New(core).With(zap.String("request_id", "req-1")))
+
+	store := WithLogging(NewInMemoryStore())
+	_, err := store.Save(ctx, URLData{ShortURL: "abc", OriginalURL: "http://example.com"})
+	assert.NoError(t, err)
+	_, err = store.Get(ctx, "missing")
+	assert.Error(t, err)
+
+	entries := logs.AllUntimed()
+	if assert.Len(t, entries, 2) {
+		save := entries[0].ContextMap()
+		assert.Equal(t, "req-1", save["request_id"])
+		assert.Equal(t, "save", save["op"])
+		assert.Equal(t, "abc", save["short_key"])
+		assert.NotContains(t, save, "error")
+
+		get := entries[1].ContextMap()
+		assert.Equal(t, "get", get["op"])
+		assert.Equal(t, "missing", get["short_key"])
+		assert.Contains(t, get, "error")
+	}
+}
+
+func TestPostgresStore_Spans(t *testing.T) {
+	provider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(provider)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	store := &PostgresStore{db: db}
+
+	mock.ExpectExec(regexp.QuoteMeta(setDisabledQuery)).
+		WithArgs(true, "missing").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(setDisabledQuery)).
+		WithArgs(true, "abc").
+		WillReturnError(sql.ErrConnDone)
+
+	assert.ErrorIs(t, store.SetDisabled(context.Background(), "missing", true), ErrNotFound)
+	assert.Error(t, store.SetDisabled(context.Background(), "abc", true))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "PostgresStore.SetDisabled", spans[0].Name())
+		assert.Equal(t, codes.Unset, spans[0].Status().Code)
+		assert.Equal(t, codes.Error, spans[1].Status().Code)
+	}
 }
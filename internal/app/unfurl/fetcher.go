@@ -0,0 +1,255 @@
+// Package unfurl получает заголовок, og:*-теги и favicon страницы назначения,
+// чтобы ботам мессенджеров можно было отдать превью вместо редиректа.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/storage"
+	"golang.org/x/net/html"
+)
+
+const (
+	DefaultTimeout  = 5 * time.Second
+	DefaultMaxBytes = 512 << 10
+
+	maxRedirects   = 5
+	maxFieldLength = 512
+	userAgent      = "shortener-unfurl/1.0"
+)
+
+var (
+	ErrForbiddenAddress = errors.New("destination resolves to a non-public address")
+	ErrNotHTML          = errors.New("destination is not an HTML page")
+)
+
+// reservedPrefixes — диапазоны, не покрытые методами net.IP, куда запросы тоже не идут
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// IsPublicIP сообщает, можно ли обращаться к адресу: частные, loopback,
+// link-local и зарезервированные сети запрещены, чтобы ссылка не могла
+// заставить сервер ходить во внутреннюю сеть.
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fetcher загружает страницы с ограничением времени и размера ответа.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+func NewFetcher(timeout time.Duration, maxBytes int64) *Fetcher {
+	return newFetcher(timeout, maxBytes, IsPublicIP)
+}
+
+func newFetcher(timeout time.Duration, maxBytes int64, allowIP func(net.IP) bool) *Fetcher {
+	return &Fetcher{client: newClient(timeout, allowIP), maxBytes: maxBytes}
+}
+
+// NewClient возвращает HTTP-клиент, который ходит только на публичные адреса.
+// Его используют все фоновые запросы к страницам назначения.
+func NewClient(timeout time.Duration) *http.Client {
+	return newClient(timeout, IsPublicIP)
+}
+
+func newClient(timeout time.Duration, allowIP func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		// адрес проверяется после DNS-разрешения, поэтому не помогают
+		// ни домены с частными A-записями, ни редиректы на внутренние адреса
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowIP(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// прокси из окружения обошёл бы проверку адресов
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    timeout,
+		ResponseHeaderTimeout:  timeout,
+		MaxResponseHeaderBytes: 64 << 10,
+		DisableKeepAlives:      true,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("unsupported redirect scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// Fetch загружает rawURL и разбирает <head> страницы.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (storage.PageMeta, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return storage.PageMeta{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return storage.PageMeta{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return storage.PageMeta{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return storage.PageMeta{}, fmt.Errorf("%w: %s", ErrNotHTML, mediaType)
+	}
+
+	meta := parseHead(io.LimitReader(resp.Body, f.maxBytes), resp.Request.URL)
+	meta.FetchedAt = time.Now().UTC()
+	return meta, nil
+}
+
+// parseHead читает теги из <head> до начала <body> или конца данных.
+func parseHead(r io.Reader, base *url.URL) storage.PageMeta {
+	var meta storage.PageMeta
+	var title, description, favicon string
+	og := make(map[string]string)
+
+	z := html.NewTokenizer(r)
+loop:
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break loop
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		tok := z.Token()
+		switch tok.Data {
+		case "body":
+			break loop
+		case "title":
+			if z.Next() == html.TextToken && title == "" {
+				title = string(z.Text())
+			}
+		case "meta":
+			key := strings.ToLower(attr(tok, "property"))
+			if key == "" {
+				key = strings.ToLower(attr(tok, "name"))
+			}
+			content := attr(tok, "content")
+			switch {
+			case strings.HasPrefix(key, "og:"):
+				if _, ok := og[key]; !ok {
+					og[key] = content
+				}
+			case key == "description" && description == "":
+				description = content
+			}
+		case "link":
+			rel := strings.Fields(strings.ToLower(attr(tok, "rel")))
+			for _, r := range rel {
+				if r == "icon" && favicon == "" {
+					favicon = attr(tok, "href")
+				}
+			}
+		}
+	}
+
+	meta.Title = clean(firstNonEmpty(og["og:title"], title))
+	meta.Description = clean(firstNonEmpty(og["og:description"], description))
+	meta.SiteName = clean(og["og:site_name"])
+	meta.Image = resolve(base, og["og:image"])
+	meta.Favicon = resolve(base, firstNonEmpty(favicon, "/favicon.ico"))
+	return meta
+}
+
+func attr(tok html.Token, name string) string {
+	for _, a := range tok.Attr {
+		if strings.EqualFold(a.Key, name) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// clean схлопывает пробелы и обрезает слишком длинные значения.
+func clean(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxFieldLength {
+		s = string(r[:maxFieldLength])
+	}
+	return s
+}
+
+// resolve превращает ссылку со страницы в абсолютный http(s)-адрес.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
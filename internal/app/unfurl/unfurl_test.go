@@ -0,0 +1,147 @@
+package unfurl
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const page = `<!DOCTYPE html>
+<html><head>
+<title>  Fallback
+  title </title>
+<meta property="og:title" content="Spring sale">
+<meta name="description" content="Everything must go">
+<meta property="og:image" content="/img/banner.png">
+<meta property="og:site_name" content="Example Shop">
+<link rel="shortcut icon" href="/static/icon.png">
+</head><body><meta property="og:title" content="ignored"></body></html>`
+
+func allowAll(net.IP) bool { return true }
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/sale", http.StatusMovedPermanently)
+		case "/sale":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(page))
+		case "/plain":
+			w.Write([]byte(`<title>Bare</title>`))
+		case "/pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/huge":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><head>" + strings.Repeat("<!-- padding -->", 1000) + "<title>Too far</title>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := newFetcher(time.Second, DefaultMaxBytes, allowAll)
+
+	meta, err := fetcher.Fetch(context.Background(), server.URL+"/old")
+	assert.NoError(t, err)
+	assert.Equal(t, "Spring sale", meta.Title)
+	assert.Equal(t, "Everything must go", meta.Description)
+	assert.Equal(t, "Example Shop", meta.SiteName)
+	assert.Equal(t, server.URL+"/img/banner.png", meta.Image)
+	assert.Equal(t, server.URL+"/static/icon.png", meta.Favicon)
+	assert.False(t, meta.FetchedAt.IsZero())
+
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/pdf")
+	assert.ErrorIs(t, err, ErrNotHTML)
+
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/missing")
+	assert.Error(t, err)
+
+	// Case: Body is cut at the size limit
+	meta, err = newFetcher(time.Second, 1024, allowAll).Fetch(context.Background(), server.URL+"/huge")
+	assert.NoError(t, err)
+	assert.Empty(t, meta.Title)
+
+	// Case: Timeout
+	_, err = newFetcher(50*time.Millisecond, DefaultMaxBytes, allowAll).Fetch(context.Background(), server.URL+"/slow")
+	assert.Error(t, err)
+
+	// Case: Test server listens on loopback, which the default fetcher refuses
+	_, err = NewFetcher(time.Second, DefaultMaxBytes).Fetch(context.Background(), server.URL+"/sale")
+	assert.ErrorIs(t, err, ErrForbiddenAddress)
+}
+
+func TestFetch_RedirectToPrivateAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("second loopback address is unavailable: %v", err)
+	}
+	internal := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("internal server must not be reached")
+	}))
+	internal.Listener.Close()
+	internal.Listener = listener
+	internal.Start()
+	defer internal.Close()
+
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	}))
+	defer public.Close()
+
+	// 127.0.0.1 изображает публичный адрес, 127.0.0.2 — внутренний
+	fetcher := newFetcher(time.Second, DefaultMaxBytes, func(ip net.IP) bool {
+		return ip.Equal(net.IPv4(127, 0, 0, 1))
+	})
+
+	_, err = fetcher.Fetch(context.Background(), public.URL)
+	assert.ErrorIs(t, err, ErrForbiddenAddress)
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fc00::1", "::ffff:127.0.0.1"} {
+		assert.False(t, IsPublicIP(net.ParseIP(addr)), addr)
+	}
+	for _, addr := range []string{"93.184.216.34", "8.8.8.8", "2606:4700:4700::1111"} {
+		assert.True(t, IsPublicIP(net.ParseIP(addr)), addr)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	saved := make(chan storage.PageMeta, 1)
+	worker := NewWorker(newFetcher(time.Second, DefaultMaxBytes, allowAll), func(_ context.Context, shortURL string, meta storage.PageMeta) error {
+		assert.Equal(t, "abc", shortURL)
+		saved <- meta
+		return nil
+	}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker.Start(ctx)
+
+	assert.True(t, worker.Enqueue("abc", server.URL))
+	select {
+	case meta := <-saved:
+		assert.Equal(t, "Spring sale", meta.Title)
+	case <-time.After(2 * time.Second):
+		t.Fatal("page meta was not saved")
+	}
+
+	var disabled *Worker
+	assert.False(t, disabled.Enqueue("abc", server.URL))
+}
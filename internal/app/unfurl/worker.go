@@ -0,0 +1,84 @@
+package unfurl
+
+import (
+	"context"
+	"time"
+
+	"github.com/condratf/shortner/internal/app/logger"
+	"github.com/condratf/shortner/internal/app/storage"
+	"go.uber.org/zap"
+)
+
+const defaultQueueSize = 1024
+
+// Worker получает сведения о страницах в фоне, чтобы создание ссылки
+// не ждало ответа сайта назначения.
+type Worker struct {
+	fetcher *Fetcher
+	save    func(ctx context.Context, shortURL string, meta storage.PageMeta) error
+	workers int
+	timeout time.Duration
+	jobs    chan job
+}
+
+type job struct {
+	shortURL    string
+	originalURL string
+}
+
+func NewWorker(fetcher *Fetcher, save func(ctx context.Context, shortURL string, meta storage.PageMeta) error, workers int) *Worker {
+	return &Worker{
+		fetcher: fetcher,
+		save:    save,
+		workers: workers,
+		timeout: DefaultTimeout,
+		jobs:    make(chan job, defaultQueueSize),
+	}
+}
+
+// Start запускает обработчики очереди; они завершаются вместе с ctx.
+func (w *Worker) Start(ctx context.Context) {
+	for i := 0; i < w.workers; i++ {
+		go w.run(ctx)
+	}
+}
+
+// Enqueue ставит ссылку в очередь и не блокируется: при переполненной
+// очереди задача отбрасывается. Безопасен для nil, когда получение отключено.
+func (w *Worker) Enqueue(shortURL, originalURL string) bool {
+	if w == nil {
+		return false
+	}
+	select {
+	case w.jobs <- job{shortURL: shortURL, originalURL: originalURL}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *Worker) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-w.jobs:
+			w.process(ctx, j)
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, j job) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	ctx = logger.With(ctx, zap.String("short_key", j.shortURL))
+	meta, err := w.fetcher.Fetch(ctx, j.originalURL)
+	if err != nil {
+		logger.FromContext(ctx).Warn("unfurl failed", zap.Error(err))
+		return
+	}
+	if err := w.save(ctx, j.shortURL, meta); err != nil {
+		logger.FromContext(ctx).Error("could not save page meta", zap.Error(err))
+	}
+}
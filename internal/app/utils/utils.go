@@ -1,6 +1,16 @@
 package utils
 
-import "net/url"
+import (
+	"errors"
+	"net/url"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidURL    = errors.New("invalid URL")
+	ErrWrongPassword = errors.New("wrong password")
+)
 
 func ConstructURL(baseURL string, paths ...string) (string, error) {
 	parsedURL, err := url.Parse(baseURL)
@@ -13,3 +23,35 @@ func ConstructURL(baseURL string, paths ...string) (string, error) {
 	}
 	return parsedURL.String(), nil
 }
+
+// ValidateURL проверяет, что rawURL — абсолютный http(s)-адрес с хостом.
+func ValidateURL(rawURL string) error {
+	parsedURL, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return ErrInvalidURL
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return ErrInvalidURL
+	}
+	if parsedURL.Host == "" {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// HashPassword возвращает bcrypt-хеш пароля ссылки.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword сверяет пароль с хешем и возвращает ErrWrongPassword при несовпадении.
+func CheckPassword(hash, password string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}